@@ -10,9 +10,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"unicode"
+
+	"go.grg.app/gdpr/internal/money"
 )
 
 var (
@@ -94,12 +95,12 @@ func parse(f io.Reader, w *csv.Writer) error {
 			continue
 		}
 		if payments != "" {
-			if _, err := strconv.ParseFloat(payments, 64); err != nil {
+			if _, err := money.Parse(payments, "GBP"); err != nil {
 				continue
 			}
 		}
 		if receipts != "" {
-			if _, err := strconv.ParseFloat(receipts, 64); err != nil {
+			if _, err := money.Parse(receipts, "GBP"); err != nil {
 				continue
 			}
 		}
@@ -6,10 +6,33 @@ import (
 	"encoding/csv"
 	"fmt"
 	"math/rand/v2"
+	"strings"
 	"testing"
 	"time"
 )
 
+func TestParse(t *testing.T) {
+	in := `SORT CODE 123456 ACCOUNT 1234-5678
+TESCO STORES | 12.34 | | 02 JAN 2024 | 987.66 | |
+SALARY | | 1,234.56 | 03 JAN 2024 | 2,222.22 | |
+BALANCE CARRIED FORWARD | n/a | | 04 JAN 2024 | 2,222.22 | |
+`
+	var out strings.Builder
+	w := csv.NewWriter(&out)
+	if err := parse(strings.NewReader(in), w); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	// amounts with thousands separators are kept, unlike rows whose
+	// amounts are not numbers
+	const want = `12-34-56 12345678,02 JAN 2024,TESCO STORES,12.34,,987.66
+12-34-56 12345678,03 JAN 2024,SALARY,,"1,234.56","2,222.22"
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
 func BenchmarkParse(b *testing.B) {
 	const lines = 1_000_000
 	var buf bytes.Buffer
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -16,20 +19,61 @@ import (
 type CLI struct {
 	API       firefly.API `embed:""`
 	TokenFile []byte      `help:"Access token file path (instead of --token)" type:"filecontent"`
+	Record    string      `help:"Record requests and responses to a cassette file" type:"path" xor:"cassette"`
+	Replay    string      `help:"Replay responses from a cassette file instead of contacting Firefly" type:"existingfile" xor:"cassette"`
+	DryRun    bool        `help:"Print the changes that would be made instead of making them"`
+	UndoDir   string      `help:"Directory of undo logs recording how to reverse each session's changes" type:"path" default:"${undo}"`
 
-	Fetch   firefly.Fetch   `cmd:"" help:"Fetch from the given path"`
-	Version firefly.Version `cmd:"" help:"Show version"`
-	Link    firefly.Link    `cmd:"" help:"Link transactions to another"`
-	Match   firefly.Match   `cmd:"" help:"Match transactions from CSV to existing Firefly transactions"`
+	Fetch      firefly.Fetch      `cmd:"" help:"Fetch from the given path"`
+	Version    firefly.Version    `cmd:"" help:"Show version"`
+	Link       firefly.Link       `cmd:"" help:"Link transactions to another"`
+	Match      firefly.Match      `cmd:"" help:"Match transactions from CSV to existing Firefly transactions"`
+	Import     firefly.Import     `cmd:"" help:"Create the transactions of a statement missing from Firefly"`
+	Reconcile  firefly.Reconcile  `cmd:"" help:"Check the running balances of a statement against Firefly"`
+	Duplicates firefly.Duplicates `cmd:"" help:"Find and merge, delete or tag duplicate transactions in an account"`
+	Undo       firefly.Undo       `cmd:"" help:"Undo the changes made during a session"`
+	Mapping    struct {
+		Test firefly.MappingTest `cmd:"" help:"Show which rule of a mapping file matches a description"`
+	} `cmd:"" help:"Work with account mapping files"`
 }
 
 func main() {
 	var cli CLI
-	k := kong.Parse(&cli)
+	var journal, undo string
+	if cache, err := os.UserCacheDir(); err == nil {
+		journal = filepath.Join(cache, "gdpr", "journal")
+		undo = filepath.Join(cache, "gdpr", "undo")
+	}
+	k := kong.Parse(&cli, kong.Vars{"journal": journal, "undo": undo})
 
 	if len(cli.TokenFile) != 0 {
 		cli.API.Token = strings.TrimSpace(string(cli.TokenFile))
 	}
+	var fetch http.RoundTripper
+	switch {
+	case cli.Record != "":
+		cli.API.Transport = &firefly.Recorder{Path: cli.Record}
+		// the undo log reads previous values without recording them
+		fetch = http.DefaultTransport
+	case cli.Replay != "":
+		c, err := firefly.LoadCassette(cli.Replay)
+		k.FatalIfErrorf(err)
+		cli.API.Transport = c
+	}
+	var dryRun *firefly.DryRun
+	if cli.DryRun {
+		dryRun = &firefly.DryRun{Transport: cli.API.Transport}
+		cli.API.Transport = dryRun
+		// withheld changes must not be recorded as done
+		cli.Match.Journal = ""
+	}
+	cli.Undo.Dir = cli.UndoDir
+	var undoLog *firefly.UndoLog
+	// replayed changes never reached Firefly, so there is nothing to undo
+	if !cli.DryRun && cli.Replay == "" && cli.UndoDir != "" && !strings.HasPrefix(k.Command(), "undo") {
+		undoLog = &firefly.UndoLog{Dir: cli.UndoDir, Transport: cli.API.Transport, Fetch: fetch}
+		cli.API.Transport = undoLog
+	}
 	k.Bind(cli.API)
 
 	sig := make(chan os.Signal, 1)
@@ -41,5 +85,24 @@ func main() {
 	}()
 	k.BindTo(ctx, (*context.Context)(nil))
 
-	k.FatalIfErrorf(k.Run(ctx))
+	err := k.Run(ctx)
+	if dryRun != nil {
+		dryRun.Summary(os.Stdout)
+	}
+	if undoLog != nil {
+		undoLog.Close()
+		if session := undoLog.Session(); session != "" {
+			command := fmt.Sprintf("firefly --undo-dir %s undo %s", quote(cli.UndoDir), session)
+			slog.Info("changes can be undone", slog.String("session", session), slog.String("command", command))
+		}
+	}
+	k.FatalIfErrorf(err)
+}
+
+// quote quotes s for a shell if it needs it.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`*?[]{}()<>|&;~#!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
@@ -2,15 +2,18 @@
 package main
 
 import (
+	"cmp"
 	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
 	"time"
+
+	"go.grg.app/gdpr/internal/csvhead"
+	"go.grg.app/gdpr/internal/money"
 )
 
 func main() {
@@ -39,44 +42,65 @@ func main() {
 }
 
 func pipe(r *csv.Reader, o *csv.Writer, acc string) error {
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	cols, err := csvhead.New(header).Indexes("amount", "description", "date")
+	if err != nil {
+		return err
+	}
+	amount, description, date := cols[0], cols[1], cols[2]
+	currency := csvhead.New(header).Index("currency_code")
+
 	var (
-		line    int
+		line    = 1
 		errs    error
-		running float64
+		running money.Money
 	)
 	for record, err := r.Read(); err != io.EOF; record, err = r.Read() {
 		line++
 		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
 			return errors.Join(errs, err)
 		}
-		if len(record) < 20 {
+		if len(record) <= max(amount, description, date) {
 			errs = errors.Join(errs, err)
 			continue
 		}
-		t, err := time.Parse(time.RFC3339, record[15])
+		t, err := time.Parse(time.RFC3339, record[date])
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("line %d: failed to parse time: %w", line, err))
 			continue
 		}
 		var payments, receipts string
-		f, err := strconv.ParseFloat(record[8], 64)
+		var code string
+		if currency >= 0 && currency < len(record) {
+			code = record[currency]
+		}
+		// rows without a currency are in that of the rows before
+		code = cmp.Or(code, running.Currency)
+		m, err := money.Parse(record[amount], code)
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("line %d: failed to parse amount: %w", line, err))
 			continue
 		}
-		if f < 0 {
-			payments = fmt.Sprintf("%.2f", -f)
+		if !running.Comparable(m) {
+			errs = errors.Join(errs, fmt.Errorf("line %d: currency %s differs from %s", line, m.Currency, running.Currency))
+			continue
+		}
+		if m.Sign() < 0 {
+			payments = m.Neg().String()
 		} else {
-			receipts = fmt.Sprintf("%.2f", f)
+			receipts = m.String()
 		}
-		running += f
+		running = running.Add(m)
 		if err := o.Write([]string{
 			acc,
 			strings.ToUpper(t.Format("02 Jan 2006")),
-			record[14], // description
+			record[description],
 			payments,
 			receipts,
-			fmt.Sprintf("%.2f", running),
+			running.String(),
 		}); err != nil {
 			return err
 		}
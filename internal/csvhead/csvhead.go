@@ -0,0 +1,64 @@
+// Package csvhead finds the columns of CSV records by the names in their
+// header row.
+package csvhead
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Header is the header row of a CSV file.
+type Header []string
+
+// New returns the header of record, trimming spaces and any byte order mark.
+func New(record []string) Header {
+	h := make(Header, len(record))
+	for i, name := range record {
+		h[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+	}
+	return h
+}
+
+// Index returns the zero-indexed column named name, ignoring case if no
+// column has exactly that name, or -1.
+func (h Header) Index(name string) int {
+	if i := slices.Index(h, name); i >= 0 {
+		return i
+	}
+	return slices.IndexFunc(h, func(s string) bool { return strings.EqualFold(s, name) })
+}
+
+// Indexes returns the zero-indexed column of each of names, or a
+// [*MissingError] if any are not in h.
+func (h Header) Indexes(names ...string) ([]int, error) {
+	indexes := make([]int, len(names))
+	var missing []string
+	for i, name := range names {
+		if indexes[i] = h.Index(name); indexes[i] < 0 {
+			missing = append(missing, name)
+		}
+	}
+	if missing != nil {
+		return nil, &MissingError{Missing: missing, Found: h}
+	}
+	return indexes, nil
+}
+
+// MissingError reports columns missing from a header.
+type MissingError struct {
+	Missing []string
+	Found   Header
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("missing columns %s; found %s", quote(e.Missing), quote(e.Found))
+}
+
+func quote(names []string) string {
+	q := make([]string, len(names))
+	for i, name := range names {
+		q[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(q, ", ")
+}
@@ -0,0 +1,27 @@
+package csvhead
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestIndexes(t *testing.T) {
+	h := New([]string{"\ufeffDate", " Description ", "amount"})
+	got, err := h.Indexes("Date", "Description", "Amount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	_, err = h.Indexes("date", "Running", "Account")
+	var missing *MissingError
+	if !errors.As(err, &missing) || !slices.Equal(missing.Missing, []string{"Running", "Account"}) {
+		t.Fatalf("got error %v, want Running and Account missing", err)
+	}
+	if want := `missing columns "Running", "Account"; found "Date", "Description", "amount"`; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
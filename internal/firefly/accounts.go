@@ -0,0 +1,117 @@
+package firefly
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// accounts caches the asset, expense and revenue accounts that can be
+// picked as the opposing account of a transaction, fetching them once per
+// session.
+type accounts struct {
+	ctx  context.Context
+	a    API
+	list []Object[Account]
+	ok   bool
+}
+
+func (c *accounts) load() ([]Object[Account], error) {
+	if c.ok {
+		return c.list, nil
+	}
+	for _, t := range []string{"asset", "expense", "revenue"} {
+		list, err := Collect(c.a.Accounts(c.ctx, t))
+		if err != nil {
+			return nil, err
+		}
+		c.list = append(c.list, list...)
+	}
+	c.ok = true
+	return c.list, nil
+}
+
+// create stores a new account of the given type and adds it to the cache.
+func (c *accounts) create(name, accountType string) (int, error) {
+	o, err := c.a.CreateAccount(c.ctx, Account{Name: name, Type: accountType, Active: true})
+	if err != nil {
+		return 0, err
+	}
+	if c.ok {
+		c.list = append(c.list, o)
+	}
+	return int(o.ID), nil
+}
+
+// search returns the indexes of the accounts matching query by name, IBAN
+// or ID, best first, or of every active account if query is empty.
+func search(list []Object[Account], query string) []int {
+	type match struct {
+		i     int
+		score float64
+	}
+	var matches []match
+	for i, o := range list {
+		if query == "" {
+			if o.Attributes.Active {
+				matches = append(matches, match{i, 0})
+			}
+			continue
+		}
+		score, ok := fuzzy(query, o.Attributes.Name)
+		if s, iban := fuzzy(query, o.Attributes.IBAN); iban && s > score {
+			score, ok = s, true
+		}
+		if strconv.Itoa(int(o.ID)) == query {
+			score, ok = 2, true
+		}
+		if ok {
+			matches = append(matches, match{i, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), strings.Compare(list[a.i].Attributes.Name, list[b.i].Attributes.Name))
+	})
+	out := make([]int, len(matches))
+	for i, m := range matches {
+		out[i] = m.i
+	}
+	return out
+}
+
+// fuzzy reports whether the letters and digits of query appear in order in
+// s, ignoring case, and scores the match from 0 to 1, higher the earlier
+// and closer together they are.
+func fuzzy(query, s string) (float64, bool) {
+	q := []rune(strings.ToLower(strings.Map(alnum, query)))
+	if len(q) == 0 {
+		return 0, false
+	}
+	r := []rune(strings.ToLower(strings.Map(alnum, s)))
+	start, gaps, j := -1, 0, 0
+	for i := 0; i < len(r) && j < len(q); i++ {
+		switch {
+		case r[i] == q[j]:
+			if start < 0 {
+				start = i
+			}
+			j++
+		case start >= 0:
+			gaps++
+		}
+	}
+	if j < len(q) {
+		return 0, false
+	}
+	return float64(len(q)) / float64(len(q)+gaps+start), true
+}
+
+func alnum(r rune) rune {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return r
+	}
+	return -1
+}
@@ -0,0 +1,35 @@
+package firefly
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearchAccounts(t *testing.T) {
+	list := []Object[Account]{
+		{ID: 1, Attributes: Account{Name: "Current account", Type: "asset", IBAN: "GB33 BUKB 2020 1555 5555 55", Active: true}},
+		{ID: 2, Attributes: Account{Name: "Tesco", Type: "expense", Active: true}},
+		{ID: 3, Attributes: Account{Name: "Tesco Bank", Type: "revenue"}},
+		{ID: 12, Attributes: Account{Name: "Amazon", Type: "expense", Active: true}},
+	}
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"", "[12 1 2]"},
+		{"tesco", "[2 3]"},
+		{"tsb", "[3]"},
+		{"gb33bukb", "[1]"},
+		{"12", "[12]"},
+		{"amz", "[12]"},
+		{"zzz", "[]"},
+	} {
+		var ids []int
+		for _, i := range search(list, tt.query) {
+			ids = append(ids, int(list[i].ID))
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("search %q: got %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
@@ -1,20 +1,33 @@
 package firefly
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
 )
 
 type API struct {
-	Endpoint *url.URL `short:"e" help:"URL to Firefly" required:"" env:"FIREFLY_URL,FIREFLY_III_URL"`
-	Token    string   `short:"t" help:"Access token (generate at /profile)" required:"" xor:"token,token-file" env:"FIREFLY_ACCESS_TOKEN"`
+	Endpoint   *url.URL      `short:"e" help:"URL to Firefly" required:"" env:"FIREFLY_URL,FIREFLY_III_URL"`
+	Token      string        `short:"t" help:"Access token (generate at /profile)" required:"" xor:"token,token-file" env:"FIREFLY_ACCESS_TOKEN"`
+	Timeout    time.Duration `help:"Timeout for each request attempt, zero for none" default:"30s"`
+	Retries    int           `help:"Times to retry idempotent requests after rate limiting or server errors" default:"3"`
+	RetryDelay time.Duration `help:"Delay before the first retry, doubled on each subsequent retry" default:"1s"`
+
+	// Transport makes requests to Firefly, or [http.DefaultTransport] if nil.
+	Transport http.RoundTripper `kong:"-"`
 }
 
 func (a API) authHeader() http.Header {
@@ -25,11 +38,33 @@ func (a API) authHeader() http.Header {
 	return h
 }
 
-type overrideReaderContextKey struct{}
+// Pagination is the metadata Firefly returns alongside list responses.
+type Pagination struct {
+	Total       int `json:"total"`
+	Count       int `json:"count"`
+	PerPage     int `json:"per_page"`
+	CurrentPage int `json:"current_page"`
+	TotalPages  int `json:"total_pages"`
+}
 
-var OverrideReaderContextKey = overrideReaderContextKey{}
+type envelope[T any] struct {
+	Data *T `json:"data"`
+	Meta struct {
+		Pagination Pagination `json:"pagination"`
+	} `json:"meta"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
 
+// Do makes a single request and decodes its data into out. List endpoints
+// only return their first page; use [Pages] or [All] to read every page.
 func Do[T any](ctx context.Context, a API, method, path string, q url.Values, out *T, r io.Reader) error {
+	resp := envelope[T]{Data: out}
+	return do(ctx, a, method, path, q, &resp, r)
+}
+
+func do[T any](ctx context.Context, a API, method, path string, q url.Values, resp *envelope[T], r io.Reader) error {
 	u, err := url.JoinPath(a.Endpoint.String(), "api/v1", path)
 	if err != nil {
 		panic(err)
@@ -38,71 +73,202 @@ func Do[T any](ctx context.Context, a API, method, path string, q url.Values, ou
 		u += "?" + q.Encode()
 	}
 	slog.Info("making request", slog.String("method", method), slog.String("url", u))
-	var body io.Reader
-	if value := ctx.Value(OverrideReaderContextKey); value != nil {
-		body = value.(io.Reader)
-	} else {
-		req, err := http.NewRequestWithContext(ctx, method, u, r)
-		if err != nil {
+	var payload []byte
+	if r != nil {
+		if payload, err = io.ReadAll(r); err != nil {
 			return err
 		}
-		req.Header = a.authHeader()
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
+	}
+	for attempt := 0; ; attempt++ {
+		err := a.roundTrip(ctx, method, u, payload, func(body io.Reader) error {
+			return decode(body, resp)
+		})
+		wait, ok := a.retry(ctx, method, attempt, err)
+		if !ok {
 			return err
 		}
-		defer res.Body.Close()
-		switch res.StatusCode {
-		case http.StatusOK:
-			body = res.Body
-		case http.StatusNoContent:
-			return nil
-		default:
-			body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
-			return fmt.Errorf("status %d: %s", res.StatusCode, string(body))
+		slog.Warn("retrying request", slog.String("method", method), slog.String("url", u), slog.Int("attempt", attempt+1), slog.Duration("wait", wait), slog.String("err", err.Error()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
 		}
 	}
-	resp := struct {
-		Data *T `json:"data"`
-		Meta struct {
-			Pagination struct {
-				Count int `json:"count"`
-				Total int `json:"total"`
-			} `json:"pagination"`
-		} `json:"meta"`
-	}{Data: out}
+}
+
+// roundTrip makes a single attempt at a request, passing a successful
+// response body to decode.
+func (a API) roundTrip(ctx context.Context, method, u string, payload []byte, decode func(io.Reader) error) error {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header = a.authHeader()
+	res, err := (&http.Client{Transport: a.Transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return decode(res.Body)
+	case http.StatusNoContent:
+		return nil
+	default:
+		return newAPIError(res)
+	}
+}
+
+// retry reports whether a request that failed with err should be attempted
+// again and how long to wait before doing so. Only idempotent requests are
+// retried, after rate limiting, server errors or a failed connection.
+func (a API) retry(ctx context.Context, method string, attempt int, err error) (time.Duration, bool) {
+	if err == nil || attempt >= a.Retries || ctx.Err() != nil {
+		return 0, false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return 0, false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && !apiErr.Temporary() {
+		return 0, false
+	}
+	var (
+		semanticErr *json.SemanticError
+		syntaxErr   *jsontext.SyntacticError
+	)
+	if errors.As(err, &semanticErr) || errors.As(err, &syntaxErr) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		// the response arrived but was not what was expected
+		return 0, false
+	}
+	// exponential backoff with jitter over the upper half of the interval
+	wait := a.RetryDelay << attempt
+	if wait > 0 {
+		wait = wait/2 + rand.N(wait/2+1)
+	}
+	if apiErr != nil && apiErr.RetryAfter > wait {
+		wait = apiErr.RetryAfter
+	}
+	return wait, true
+}
+
+func decode[T any](body io.Reader, resp *envelope[T]) error {
 	defer func() {
-		slog.Info("pagination", slog.Int("count", resp.Meta.Pagination.Count), slog.Int("total", resp.Meta.Pagination.Total))
+		if p := resp.Meta.Pagination; p.Total > 0 {
+			slog.Info("pagination", slog.Int("page", p.CurrentPage), slog.Int("pages", p.TotalPages), slog.Int("count", p.Count), slog.Int("total", p.Total))
+		}
 	}()
-	return json.UnmarshalRead(body, &resp)
+	return json.UnmarshalRead(body, resp)
 }
 
-type StringInt int
+// Pages makes a GET request to path and yields the data of each page,
+// following links.next (or meta.pagination when no link is given) until
+// Firefly reports no further pages or ctx is cancelled.
+func Pages[T any](ctx context.Context, a API, path string, q url.Values) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		q := maps.Clone(q)
+		for {
+			var data T
+			if err := ctx.Err(); err != nil {
+				yield(data, err)
+				return
+			}
+			resp := envelope[T]{Data: &data}
+			if err := do(ctx, a, http.MethodGet, path, q, &resp, nil); err != nil {
+				yield(data, err)
+				return
+			}
+			if !yield(data, nil) {
+				return
+			}
+			next, err := nextPage(q, resp.Meta.Pagination, resp.Links.Next)
+			if err != nil {
+				yield(data, err)
+				return
+			}
+			if next == nil {
+				return
+			}
+			q = next
+		}
+	}
+}
 
-func (s StringInt) MarshalText() ([]byte, error) {
-	return []byte(strconv.Itoa(int(s))), nil
+func nextPage(q url.Values, p Pagination, link string) (url.Values, error) {
+	if p.CurrentPage >= p.TotalPages {
+		return nil, nil
+	}
+	next := maps.Clone(q)
+	if next == nil {
+		next = url.Values{}
+	}
+	if link != "" {
+		u, err := url.Parse(link)
+		if err != nil {
+			return nil, fmt.Errorf("next page link: %w", err)
+		}
+		// the link's parameters override the request's, keeping any
+		// filters the server leaves out of it
+		maps.Copy(next, u.Query())
+	}
+	if page, err := strconv.Atoi(next.Get("page")); err != nil || page <= p.CurrentPage {
+		next.Set("page", strconv.Itoa(p.CurrentPage+1))
+	}
+	return next, nil
 }
 
-func (s *StringInt) UnmarshalText(b []byte) error {
-	value, err := strconv.Atoi(string(b))
-	if err != nil {
-		return err
+// All yields every item of a list endpoint across all of its pages.
+func All[T any](ctx context.Context, a API, path string, q url.Values) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for page, err := range Pages[[]T](ctx, a, path, q) {
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, v := range page {
+				if !yield(v, nil) {
+					return
+				}
+			}
+		}
 	}
-	*s = StringInt(value)
-	return nil
 }
 
-type StringFloat float64
+// Collect drains seq into a slice, stopping at the first error.
+func Collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var s []T
+	for v, err := range seq {
+		if err != nil {
+			return s, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
 
-func (s StringFloat) MarshalText() ([]byte, error) {
-	return []byte(strconv.FormatFloat(float64(s), 'f', 2, 64)), nil
+type StringInt int
+
+func (s StringInt) MarshalText() ([]byte, error) {
+	return []byte(strconv.Itoa(int(s))), nil
 }
 
-func (s *StringFloat) UnmarshalText(b []byte) error {
-	value, err := strconv.ParseFloat(string(b), 64)
+func (s *StringInt) UnmarshalText(b []byte) error {
+	value, err := strconv.Atoi(string(b))
 	if err != nil {
 		return err
 	}
-	*s = StringFloat(value)
+	*s = StringInt(value)
 	return nil
 }
@@ -0,0 +1,179 @@
+package firefly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testAPI(t *testing.T, h http.HandlerFunc) API {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return API{Endpoint: u, Token: "token", Retries: 2, RetryDelay: time.Millisecond}
+}
+
+func TestAll(t *testing.T) {
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+		}
+		next := ""
+		if page != "3" {
+			next = fmt.Sprintf(`"next":"http://elsewhere/api/v1/tags?page=%c"`, page[0]+1)
+		}
+		fmt.Fprintf(w, `{"data":[{"id":"%s"}],"meta":{"pagination":{"total":3,"count":1,"per_page":1,"current_page":%s,"total_pages":3}},"links":{%s}}`, page, page, next)
+	})
+	tags, err := Collect(a.Tags(t.Context()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 || tags[2].ID != 3 {
+		t.Errorf("got %v, want 3 tags", tags)
+	}
+}
+
+func TestAllKeepsQuery(t *testing.T) {
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("start") != "2024-01-01" {
+			http.Error(w, "no start", http.StatusBadRequest)
+			return
+		}
+		page := q.Get("page")
+		if page == "" {
+			page = "1"
+		}
+		fmt.Fprintf(w, `{"data":[{"id":"%s"}],"meta":{"pagination":{"total":2,"count":1,"per_page":1,"current_page":%s,"total_pages":2}},"links":{"next":"http://elsewhere/api/v1/tags?page=2"}}`, page, page)
+	})
+	tags, err := Collect(All[Object[Tag]](t.Context(), a, "tags", url.Values{"start": {"2024-01-01"}}))
+	if err != nil || len(tags) != 2 {
+		t.Errorf("got %v, %v, want 2 tags", tags, err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var attempts int
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"version":"6.2.0"}}`)
+	})
+	about, err := a.About(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if about.Version != "6.2.0" || attempts != 3 {
+		t.Errorf("got version %q after %d attempts", about.Version, attempts)
+	}
+}
+
+func TestRetryNotIdempotent(t *testing.T) {
+	var attempts int
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err := a.CreateTag(t.Context(), Tag{Tag: "gdpr"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want status 503", err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want 1", attempts)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	a.Timeout, a.Retries = time.Millisecond, 0
+	if _, err := a.About(t.Context()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"message":"The given data was invalid.","errors":{"transactions.0.source_id":["This value is invalid for this field."]}}`)
+	})
+	_, err := a.CreateTransaction(t.Context(), TransactionGroup{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %T, want *APIError", err)
+	}
+	if got := apiErr.Split(0)["source_id"]; len(got) != 1 {
+		t.Errorf("got errors %v", apiErr.Errors)
+	}
+	const want = "status 422: The given data was invalid.; transactions.0.source_id: This value is invalid for this field."
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+	if _, ok := apiErr.Duplicate(); ok {
+		t.Error("got duplicate")
+	}
+	dup := &APIError{Errors: map[string][]string{"transactions.0.description": {"Duplicate of transaction #42."}}}
+	if id, ok := dup.Duplicate(); !ok || id != 42 {
+		t.Errorf("got duplicate %d %v, want 42", id, ok)
+	}
+}
+
+// keep answers every correction with the value given, as pressing esc in
+// the review does.
+type keep struct{ policy }
+
+func (keep) correct(_, value string) (string, error) { return value, nil }
+
+func TestUpsertUnchanged(t *testing.T) {
+	var posts int
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"message":"The given data was invalid.","errors":{"transactions.0.description":["The description is invalid."]}}`)
+	})
+	g := TransactionGroup{Transactions: []Transaction{{Description: "TESCO"}}}
+	_, err := upsert(t.Context(), a, keep{}, 0, g)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || posts != 1 {
+		t.Errorf("got %v after %d requests, want the rejection after 1", err, posts)
+	}
+
+	posts = 0
+	_, err = upsert(t.Context(), a, policy{}, 0, g)
+	var u *unresolvedError
+	if !errors.As(err, &u) || !errors.As(err, &apiErr) || posts != 1 {
+		t.Errorf("got %v after %d requests, want an unresolved rejection after 1", err, posts)
+	}
+}
+
+func TestTransactionCurrency(t *testing.T) {
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"id": "1", "attributes": {"transactions": [
+			{"amount": "1500.000000000000", "currency_code": "JPY", "foreign_amount": "1.234000000000", "foreign_currency_code": "BHD"},
+			{"amount": "0.120000000000", "currency_code": "GBP", "foreign_amount": null}
+		]}}}`)
+	})
+	g, err := a.Transaction(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"1500 JPY 1.234 BHD", "0.12 GBP 0.00 "} {
+		tr := g.Attributes.Transactions[i]
+		if got := fmt.Sprintf("%s %s %s %s", tr.Amount, tr.Amount.Currency, tr.ForeignAmount, tr.ForeignAmount.Currency); got != want {
+			t.Errorf("split %d: got %q, want %q", i, got, want)
+		}
+	}
+}
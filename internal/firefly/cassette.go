@@ -0,0 +1,210 @@
+package firefly
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// Cassette is a recorded sequence of requests to Firefly and their
+// responses. It implements [http.RoundTripper] to replay the responses
+// without contacting Firefly.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// Interaction is a single request and response of a [Cassette]. Request URLs
+// are recorded without the scheme and host, so a cassette can be replayed
+// against any endpoint.
+type Interaction struct {
+	Request struct {
+		Method string         `json:"method"`
+		URL    string         `json:"url"`
+		Body   jsontext.Value `json:"body,omitzero"`
+	} `json:"request"`
+	Response struct {
+		Status int            `json:"status"`
+		Body   jsontext.Value `json:"body,omitzero"`
+	} `json:"response"`
+}
+
+// LoadCassette reads a cassette previously written by a [Recorder].
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// RoundTrip replays the response of the first interaction not yet replayed
+// with the same method and URL. If the interaction recorded a request body,
+// the request must have an equivalent body.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used == nil {
+		c.used = make([]bool, len(c.Interactions))
+	}
+	for i, in := range c.Interactions {
+		if c.used[i] || in.Request.Method != req.Method || in.Request.URL != req.URL.RequestURI() {
+			continue
+		}
+		if len(in.Request.Body) > 0 && !equalJSON(in.Request.Body, body) {
+			return nil, fmt.Errorf("cassette: %s %s: body %s does not match recorded %s", req.Method, req.URL.RequestURI(), body, in.Request.Body)
+		}
+		c.used[i] = true
+		return &http.Response{
+			Status:        strconv.Itoa(in.Response.Status) + " " + http.StatusText(in.Response.Status),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(bytes.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette: no interaction recorded for %s %s", req.Method, req.URL.RequestURI())
+}
+
+// Unused returns the interactions that have not been replayed.
+func (c *Cassette) Unused() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var unused []Interaction
+	for i, in := range c.Interactions {
+		if i >= len(c.used) || !c.used[i] {
+			unused = append(unused, in)
+		}
+	}
+	return unused
+}
+
+// Recorder is an [http.RoundTripper] that records every request and
+// response made through Transport to a cassette file at Path, appending
+// each interaction to the file as it is made.
+type Recorder struct {
+	Path      string
+	Transport http.RoundTripper
+
+	mu sync.Mutex
+	// end is the offset of the end of the last interaction written, where
+	// the next is written over the closing brackets.
+	end int64
+}
+
+// cassetteEnd closes the interactions array and cassette object of a
+// recording.
+const cassetteEnd = "\n\t]\n}\n"
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	var in Interaction
+	in.Request.Method = req.Method
+	in.Request.URL = req.URL.RequestURI()
+	in.Request.Body = asJSON(body)
+	in.Response.Status = res.StatusCode
+	in.Response.Body = asJSON(resBody)
+	if err := r.append(in); err != nil {
+		return nil, fmt.Errorf("recording %s %s: %w", req.Method, in.Request.URL, err)
+	}
+	return res, nil
+}
+
+// append writes in to the end of the cassette, starting the file if it is
+// the first interaction, so the file is a complete cassette after each.
+func (r *Recorder) append(in Interaction) error {
+	b, err := json.Marshal(in, jsontext.Multiline(true), jsontext.WithIndentPrefix("\t\t"), jsontext.WithIndent("\t"))
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	flag, sep := os.O_WRONLY, ",\n\t\t"
+	if r.end == 0 {
+		flag, sep = os.O_WRONLY|os.O_CREATE|os.O_TRUNC, "{\n\t\"interactions\": [\n\t\t"
+	}
+	f, err := os.OpenFile(r.Path, flag, 0o600)
+	if err != nil {
+		return err
+	}
+	entry := append([]byte(sep), b...)
+	if _, err := f.WriteAt(append(entry, cassetteEnd...), r.end); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	r.end += int64(len(entry))
+	return nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+// asJSON returns b as a JSON value, quoting it as a string if it is not JSON.
+func asJSON(b []byte) jsontext.Value {
+	if len(b) == 0 {
+		return nil
+	}
+	v := jsontext.Value(bytes.Clone(b))
+	if v.IsValid() {
+		return v
+	}
+	v, _ = jsontext.AppendQuote(nil, b)
+	return v
+}
+
+// equalJSON reports whether a and b are equivalent JSON, ignoring
+// formatting and the order of object members.
+func equalJSON(a, b jsontext.Value) bool {
+	a, b = a.Clone(), b.Clone()
+	_, _ = a.Canonicalize(), b.Canonicalize()
+	return bytes.Equal(a, b)
+}
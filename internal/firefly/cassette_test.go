@@ -0,0 +1,98 @@
+package firefly
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// replay returns an API replaying the named cassette from testdata, failing
+// the test if any recorded interaction is not replayed.
+func replay(t *testing.T, name string) API {
+	t.Helper()
+	c, err := LoadCassette(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, in := range c.Unused() {
+			t.Errorf("interaction not replayed: %s %s", in.Request.Method, in.Request.URL)
+		}
+	})
+	u, _ := url.Parse("https://firefly.example")
+	return API{Endpoint: u, Token: "token", Transport: c}
+}
+
+func TestVersion(t *testing.T) {
+	if err := (Version{}).Run(t.Context(), replay(t, "version")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	if err := (Fetch{Path: "tags", Method: "GET"}).Run(t.Context(), replay(t, "fetch")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	if err := (Link{Query: "has_any_notes:true"}).Run(t.Context(), replay(t, "link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	m := Match{
+		AccountID:       1,
+		File:            []byte("01 Jan 24,TESCO STORES,-12.34\n"),
+		KeepDescription: true,
+		Tag:             "gdpr",
+		ColDate:         1,
+		DateFormat:      "02 Jan 06",
+		ColDescription:  2,
+		ColAmount:       3,
+	}
+	if err := m.Run(t.Context(), replay(t, "match")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "about.json")
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"version":"6.2.0"}}`)
+	})
+	live := a
+	a.Transport = &Recorder{Path: path}
+	for range 2 {
+		if _, err := a.About(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Transport = c
+	for range 2 {
+		about, err := a.About(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if about.Version != "6.2.0" {
+			t.Errorf("got version %q, want 6.2.0", about.Version)
+		}
+	}
+	if unused := c.Unused(); len(unused) != 0 {
+		t.Errorf("got %d interactions not replayed", len(unused))
+	}
+
+	live.Transport = &Recorder{Path: filepath.Join(t.TempDir(), "missing", "about.json")}
+	live.Retries = 0
+	if _, err := live.About(t.Context()); err == nil {
+		t.Error("got no error recording to a missing directory")
+	}
+}
@@ -0,0 +1,170 @@
+package firefly
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-json-experiment/json"
+)
+
+func get[T any](ctx context.Context, a API, path string, q url.Values) (Object[T], error) {
+	var out Object[T]
+	err := Do(ctx, a, http.MethodGet, path, q, &out, nil)
+	return out, err
+}
+
+func index[T any](ctx context.Context, a API, path string, q url.Values) iter.Seq2[Object[T], error] {
+	return All[Object[T]](ctx, a, path, q)
+}
+
+func send[T any](ctx context.Context, a API, method, path string, v T) (Object[T], error) {
+	var out Object[T]
+	body, err := json.Marshal(v)
+	if err != nil {
+		return out, err
+	}
+	err = Do(ctx, a, method, path, nil, &out, bytes.NewReader(body))
+	return out, err
+}
+
+func remove(ctx context.Context, a API, path string) error {
+	var out any
+	return Do(ctx, a, http.MethodDelete, path, nil, &out, nil)
+}
+
+func typed(t string) url.Values {
+	if t == "" {
+		return nil
+	}
+	return url.Values{"type": {t}}
+}
+
+// About returns the Firefly version information.
+func (a API) About(ctx context.Context) (About, error) {
+	var out About
+	err := Do(ctx, a, http.MethodGet, "about", nil, &out, nil)
+	return out, err
+}
+
+// SearchTransactions yields transactions matching a Firefly search query.
+func (a API) SearchTransactions(ctx context.Context, query string) iter.Seq2[Object[TransactionGroup], error] {
+	return index[TransactionGroup](ctx, a, "search/transactions", url.Values{"query": {query}})
+}
+
+// Transactions yields transactions filtered by q, such as start, end and type.
+func (a API) Transactions(ctx context.Context, q url.Values) iter.Seq2[Object[TransactionGroup], error] {
+	return index[TransactionGroup](ctx, a, "transactions", q)
+}
+
+// Transaction returns the transaction group with the given ID.
+func (a API) Transaction(ctx context.Context, id int) (Object[TransactionGroup], error) {
+	return get[TransactionGroup](ctx, a, "transactions/"+strconv.Itoa(id), nil)
+}
+
+// CreateTransaction stores a new transaction group.
+func (a API) CreateTransaction(ctx context.Context, g TransactionGroup) (Object[TransactionGroup], error) {
+	return send(ctx, a, http.MethodPost, "transactions", g)
+}
+
+// UpdateTransaction updates the transaction group with the given ID.
+func (a API) UpdateTransaction(ctx context.Context, id int, g TransactionGroup) (Object[TransactionGroup], error) {
+	return send(ctx, a, http.MethodPut, "transactions/"+strconv.Itoa(id), g)
+}
+
+// DeleteTransaction deletes the transaction group with the given ID.
+func (a API) DeleteTransaction(ctx context.Context, id int) error {
+	return remove(ctx, a, "transactions/"+strconv.Itoa(id))
+}
+
+// Accounts yields accounts of the given type, such as "asset", "expense" or
+// "revenue", or all accounts if empty.
+func (a API) Accounts(ctx context.Context, accountType string) iter.Seq2[Object[Account], error] {
+	return index[Account](ctx, a, "accounts", typed(accountType))
+}
+
+// Account returns the account with the given ID.
+func (a API) Account(ctx context.Context, id int) (Object[Account], error) {
+	return get[Account](ctx, a, "accounts/"+strconv.Itoa(id), nil)
+}
+
+// AccountOn returns the account with the given ID with its balance at the
+// end of date.
+func (a API) AccountOn(ctx context.Context, id int, date time.Time) (Object[Account], error) {
+	return get[Account](ctx, a, "accounts/"+strconv.Itoa(id), url.Values{"date": {date.Format(time.DateOnly)}})
+}
+
+// AccountTransactions yields transactions of an account filtered by q.
+func (a API) AccountTransactions(ctx context.Context, id int, q url.Values) iter.Seq2[Object[TransactionGroup], error] {
+	return index[TransactionGroup](ctx, a, "accounts/"+strconv.Itoa(id)+"/transactions", q)
+}
+
+// CreateAccount stores a new account.
+func (a API) CreateAccount(ctx context.Context, acc Account) (Object[Account], error) {
+	return send(ctx, a, http.MethodPost, "accounts", acc)
+}
+
+// Tags yields all tags.
+func (a API) Tags(ctx context.Context) iter.Seq2[Object[Tag], error] {
+	return index[Tag](ctx, a, "tags", nil)
+}
+
+// CreateTag stores a new tag.
+func (a API) CreateTag(ctx context.Context, t Tag) (Object[Tag], error) {
+	return send(ctx, a, http.MethodPost, "tags", t)
+}
+
+// Categories yields all categories.
+func (a API) Categories(ctx context.Context) iter.Seq2[Object[Category], error] {
+	return index[Category](ctx, a, "categories", nil)
+}
+
+// CreateCategory stores a new category.
+func (a API) CreateCategory(ctx context.Context, c Category) (Object[Category], error) {
+	return send(ctx, a, http.MethodPost, "categories", c)
+}
+
+// Budgets yields all budgets.
+func (a API) Budgets(ctx context.Context) iter.Seq2[Object[Budget], error] {
+	return index[Budget](ctx, a, "budgets", nil)
+}
+
+// TransactionLinks yields all links between transactions.
+func (a API) TransactionLinks(ctx context.Context) iter.Seq2[Object[TransactionLink], error] {
+	return index[TransactionLink](ctx, a, "transaction-links", nil)
+}
+
+// JournalLinks yields the links to and from the transaction journal with
+// the given ID.
+func (a API) JournalLinks(ctx context.Context, id int) iter.Seq2[Object[TransactionLink], error] {
+	return index[TransactionLink](ctx, a, "transaction-journals/"+strconv.Itoa(id)+"/links", nil)
+}
+
+// CreateTransactionLink stores a new link between two transaction journals.
+func (a API) CreateTransactionLink(ctx context.Context, l TransactionLink) (Object[TransactionLink], error) {
+	return send(ctx, a, http.MethodPost, "transaction-links", l)
+}
+
+// DeleteTransactionLink deletes the link with the given ID.
+func (a API) DeleteTransactionLink(ctx context.Context, id int) error {
+	return remove(ctx, a, "transaction-links/"+strconv.Itoa(id))
+}
+
+// LinkTypes yields all link types.
+func (a API) LinkTypes(ctx context.Context) iter.Seq2[Object[LinkType], error] {
+	return index[LinkType](ctx, a, "link-types", nil)
+}
+
+// Rules yields all rules.
+func (a API) Rules(ctx context.Context) iter.Seq2[Object[Rule], error] {
+	return index[Rule](ctx, a, "rules", nil)
+}
+
+// Attachments yields all attachments.
+func (a API) Attachments(ctx context.Context) iter.Seq2[Object[Attachment], error] {
+	return index[Attachment](ctx, a, "attachments", nil)
+}
@@ -0,0 +1,180 @@
+package firefly
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-json-experiment/json"
+)
+
+// DryRun is an [http.RoundTripper] that passes GET requests to Transport and
+// records every other request instead of sending it, responding as though
+// Firefly accepted it. Created resources are given negative IDs.
+type DryRun struct {
+	Transport http.RoundTripper
+
+	mu      sync.Mutex
+	changes []change
+}
+
+// change is a request withheld by [DryRun], with the resource as it was
+// before for updates.
+type change struct {
+	method, path string
+	body, before any
+}
+
+func (d *DryRun) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := d.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.RoundTrip(req)
+	}
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	c := change{method: req.Method, path: req.URL.Path}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &c.body); err != nil {
+			return nil, err
+		}
+	}
+	if req.Method == http.MethodPut {
+		before, err := fetch(t, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(before, &c.before); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	d.changes = append(d.changes, c)
+	id := -len(d.changes)
+	d.mu.Unlock()
+
+	res := &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if req.Method == http.MethodPost || req.Method == http.MethodPut {
+		if req.Method == http.MethodPut {
+			id, _ = strconv.Atoi(path.Base(req.URL.Path))
+		}
+		b, err := json.Marshal(map[string]any{"data": map[string]any{
+			"id":         strconv.Itoa(id),
+			"attributes": c.body,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		res.Status, res.StatusCode = "200 OK", http.StatusOK
+		res.Header.Set("Content-Type", "application/json")
+		res.Body, res.ContentLength = io.NopCloser(bytes.NewReader(b)), int64(len(b))
+	}
+	return res, nil
+}
+
+// Summary writes the changes that were withheld, showing the fields set by
+// each request and, for updates, their previous values.
+func (d *DryRun) Summary(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(w, "dry run: %d changes not sent\n", len(d.changes))
+	for _, c := range d.changes {
+		fmt.Fprintf(w, "%s %s\n", c.method, c.path)
+		fields, before := flatten(c.body), flatten(c.before)
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			v := fields[k]
+			switch old, ok := before[k]; {
+			case c.before == nil:
+				fmt.Fprintf(w, "\t%s: %s\n", k, v)
+			case !ok:
+				fmt.Fprintf(w, "\t%s: (unset) → %s\n", k, v)
+			case !sameValue(old, v):
+				fmt.Fprintf(w, "\t%s: %s → %s\n", k, old, v)
+			}
+		}
+	}
+}
+
+// flatten maps each leaf of a decoded JSON value to its JSON encoding, keyed
+// by its dotted path. Arrays of scalars, such as tags, are kept whole.
+func flatten(v any) map[string]string {
+	m := make(map[string]string)
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		join := func(k string) string {
+			if prefix == "" {
+				return k
+			}
+			return prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			for k, e := range v {
+				walk(join(k), e)
+			}
+			return
+		case []any:
+			if slices.ContainsFunc(v, func(e any) bool {
+				switch e.(type) {
+				case map[string]any, []any:
+					return true
+				}
+				return false
+			}) {
+				for i, e := range v {
+					walk(join(strconv.Itoa(i)), e)
+				}
+				return
+			}
+		}
+		if v == nil || prefix == "" {
+			return
+		}
+		b, _ := json.Marshal(v)
+		m[prefix] = string(b)
+	}
+	walk("", v)
+	return m
+}
+
+// sameValue reports whether two encoded values are equal, treating numeric
+// strings and timestamps that Firefly formats differently as equal.
+func sameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var sa, sb string
+	if json.Unmarshal([]byte(a), &sa) != nil || json.Unmarshal([]byte(b), &sb) != nil {
+		return false
+	}
+	if fa, err := strconv.ParseFloat(sa, 64); err == nil {
+		fb, err := strconv.ParseFloat(sb, 64)
+		return err == nil && fa == fb
+	}
+	if ta, err := time.Parse(time.RFC3339, sa); err == nil {
+		tb, err := time.Parse(time.RFC3339, sb)
+		return err == nil && ta.Equal(tb)
+	}
+	return false
+}
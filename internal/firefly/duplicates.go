@@ -0,0 +1,226 @@
+package firefly
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+)
+
+// Duplicates finds transactions of an account with the same type and
+// amount, dated within a window of each other and with similar
+// descriptions, and merges, deletes or tags all but one of each group.
+type Duplicates struct {
+	AccountID  int       `short:"a" required:"" help:"Account to scan"`
+	Start      time.Time `help:"Scan from this date (YYYY-MM-DD)" format:"2006-01-02"`
+	End        time.Time `help:"Scan to this date (YYYY-MM-DD)" format:"2006-01-02"`
+	Window     int       `help:"Days apart duplicates may be dated" default:"3"`
+	Similarity float64   `help:"How similar descriptions of duplicates must be, from 0 to 1" default:"0.5"`
+	Action     string    `help:"What to do with each group of duplicates: ask, report, merge (into the one kept, deleting the rest), delete (all but the one kept) or tag (every one)" enum:"ask,report,merge,delete,tag" default:"ask"`
+	Tag        string    `help:"Tag to apply to duplicates with --action=tag" default:"duplicate"`
+}
+
+func (d Duplicates) Run(ctx context.Context, a API) error {
+	q := url.Values{}
+	if !d.Start.IsZero() {
+		q.Set("start", d.Start.Format(time.DateOnly))
+	}
+	if !d.End.IsZero() {
+		q.Set("end", d.End.Format(time.DateOnly))
+	}
+	var list []candidate
+	for o, err := range a.AccountTransactions(ctx, d.AccountID, q) {
+		if err != nil {
+			return err
+		}
+		if len(o.Attributes.Transactions) > 0 {
+			list = append(list, newCandidate(o))
+		}
+	}
+	groups := duplicates(list, time.Duration(d.Window)*24*time.Hour, d.Similarity)
+
+	var rv *review
+	if d.Action == "ask" && len(groups) > 0 {
+		rv = newReview(len(groups), nil, tea.WithContext(ctx))
+		rv.m.noun, rv.m.outcomes = "group", []string{"merged", "deleted", "tagged", "skipped"}
+		defer rv.Close()
+		// log below the review instead of over it
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(slog.New(slog.NewTextHandler(rv, nil)))
+	}
+
+	tally := make(map[string]int)
+	for i, g := range groups {
+		keep := kept(g)
+		action := d.Action
+		switch action {
+		case "ask":
+			var err error
+			action, keep, err = rv.duplicates(fmt.Sprintf("Duplicates of #%d", g[keep].groupID), g, keep)
+			var u *unresolvedError
+			if errors.As(err, &u) {
+				action = "skip"
+			} else if err != nil {
+				return err
+			}
+		case "report":
+			fmt.Printf("duplicates of %s:\n", g[keep])
+			for j, c := range g {
+				if j != keep {
+					fmt.Printf("\t%s\n", c)
+				}
+			}
+		}
+		l := slog.With(slog.Int("keep", g[keep].groupID))
+
+		var outcome string
+		switch action {
+		case "merge":
+			if err := mergeDuplicates(ctx, a, g, keep); err != nil {
+				return err
+			}
+			l.Info("merged", slog.Int("duplicates", len(g)-1))
+			outcome = "merged"
+		case "delete":
+			for j, c := range g {
+				if j == keep {
+					continue
+				}
+				if err := a.DeleteTransaction(ctx, c.groupID); err != nil {
+					return err
+				}
+				l.Info("deleted", slog.Int("id", c.groupID))
+			}
+			outcome = "deleted"
+		case "tag":
+			for _, c := range g {
+				if err := tagDuplicate(ctx, a, c, d.Tag); err != nil {
+					return err
+				}
+			}
+			l.Info("tagged", slog.String("tag", d.Tag))
+			outcome = "tagged"
+		case "report":
+			outcome = "reported"
+		default:
+			outcome = "skipped"
+		}
+		tally[outcome]++
+		if rv != nil {
+			rv.finish(i+1, outcome)
+		}
+	}
+	if rv != nil {
+		rv.Close()
+	}
+	fmt.Printf("%d groups of duplicates: %d reported, %d merged, %d deleted, %d tagged, %d skipped\n",
+		len(groups), tally["reported"], tally["merged"], tally["deleted"], tally["tagged"], tally["skipped"])
+	return nil
+}
+
+// duplicates groups the transactions of list of the same type and amount,
+// dated within window of another in the group and with descriptions at
+// least similar, returning the groups of two or more, oldest first.
+func duplicates(list []candidate, window time.Duration, similar float64) [][]candidate {
+	slices.SortStableFunc(list, func(a, b candidate) int {
+		return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.groupID, b.groupID))
+	})
+	parent := make([]int, len(list))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i, x := range list {
+		for j := i + 1; j < len(list) && list[j].Date.Sub(x.Date) <= window; j++ {
+			y := list[j]
+			if x.Type == y.Type && x.Amount.Abs() == y.Amount.Abs() && similarity(x.Description, y.Description) >= similar {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	var (
+		groups [][]candidate
+		index  = make(map[int]int)
+	)
+	for i, c := range list {
+		r := root(i)
+		if _, ok := index[r]; !ok {
+			index[r] = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[index[r]] = append(groups[index[r]], c)
+	}
+	return slices.DeleteFunc(groups, func(g []candidate) bool { return len(g) < 2 })
+}
+
+// kept returns the index of the transaction of a group of duplicates to
+// keep: the first reconciled, or else the first created.
+func kept(g []candidate) int {
+	if i := slices.IndexFunc(g, func(c candidate) bool { return c.Reconciled }); i >= 0 {
+		return i
+	}
+	return slices.IndexFunc(g, func(c candidate) bool {
+		return !slices.ContainsFunc(g, func(o candidate) bool { return o.groupID < c.groupID })
+	})
+}
+
+// mergeDuplicates fills the empty details of each split of g[keep] from
+// the same split of the other duplicates, adding their tags, and deletes
+// them.
+func mergeDuplicates(ctx context.Context, a API, g []candidate, keep int) error {
+	splits := slices.Clone(g[keep].splits)
+	for i, c := range g {
+		if i == keep {
+			continue
+		}
+		for j := range min(len(splits), len(c.splits)) {
+			t, from := &splits[j], c.splits[j]
+			t.Category = cmp.Or(t.Category, from.Category)
+			t.Budget = cmp.Or(t.Budget, from.Budget)
+			t.Notes = cmp.Or(t.Notes, from.Notes)
+			t.ExternalID = cmp.Or(t.ExternalID, from.ExternalID)
+			t.InternalReference = cmp.Or(t.InternalReference, from.InternalReference)
+			for _, tag := range from.Tags {
+				if !slices.Contains(t.Tags, tag) {
+					t.Tags = append(t.Tags, tag)
+				}
+			}
+		}
+	}
+	if _, err := a.UpdateTransaction(ctx, g[keep].groupID, TransactionGroup{GroupTitle: g[keep].title, Transactions: splits}); err != nil {
+		return fmt.Errorf("merging into %d: %w", g[keep].groupID, err)
+	}
+	for i, c := range g {
+		if i == keep {
+			continue
+		}
+		if err := a.DeleteTransaction(ctx, c.groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tagDuplicate(ctx context.Context, a API, c candidate, tag string) error {
+	splits := slices.Clone(c.splits)
+	for i := range splits {
+		if !slices.Contains(splits[i].Tags, tag) {
+			splits[i].Tags = append(slices.Clip(splits[i].Tags), tag)
+		}
+	}
+	_, err := a.UpdateTransaction(ctx, c.groupID, TransactionGroup{GroupTitle: c.title, Transactions: splits})
+	return err
+}
@@ -0,0 +1,52 @@
+package firefly_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestDuplicates(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	current := s.AddAccount(firefly.Account{Name: "Current", Type: "asset"})
+	shop := s.AddAccount(firefly.Account{Name: "Shop", Type: "expense"})
+	add := func(day int, description, amount, category string, tags ...string) int {
+		return seed(s, firefly.Transaction{
+			Date: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC), Amount: money.MustParse(amount, ""),
+			Description: description, SourceID: firefly.StringInt(current), DestinationID: firefly.StringInt(shop),
+			Category: category, Tags: tags,
+		})[0]
+	}
+	first := add(1, "TESCO STORES 1234", "12.34", "", "gdpr")
+	second := add(3, "TESCO STORES", "12.34", "Groceries")
+	add(10, "TESCO STORES", "12.34", "")
+	add(2, "CAFE", "12.34", "")
+	add(2, "TESCO STORES", "3.00", "")
+
+	d := firefly.Duplicates{AccountID: current, Window: 3, Similarity: 0.5, Action: "report", Tag: "duplicate"}
+	out, err := stdout(t, func() error { return d.Run(t.Context(), s.API()) })
+	if err != nil || !strings.Contains(out, "1 groups of duplicates: 1 reported, 0 merged, 0 deleted, 0 tagged, 0 skipped") {
+		t.Fatalf("got %v:\n%s", err, out)
+	}
+
+	d.Action = "merge"
+	if err := d.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Transaction(second); ok {
+		t.Error("duplicate not deleted")
+	}
+	g, _ := s.Transaction(first)
+	if tr := g.Transactions[0]; tr.Category != "Groceries" || !slices.Equal(tr.Tags, []string{"gdpr"}) {
+		t.Errorf("merged into %+v", tr)
+	}
+	if ids := s.Transactions(); len(ids) != 4 {
+		t.Errorf("got %d transactions, want 4", len(ids))
+	}
+}
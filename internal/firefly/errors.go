@@ -0,0 +1,114 @@
+package firefly
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-json-experiment/json"
+)
+
+// APIError is returned when Firefly responds with an unsuccessful status.
+// Message and Errors are populated from the response body when it is JSON,
+// where Errors holds validation messages keyed by field, such as
+// "transactions.0.source_id".
+type APIError struct {
+	StatusCode int
+	Message    string              `json:"message"`
+	Errors     map[string][]string `json:"errors"`
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration `json:"-"`
+	// Body is the start of the response body when it could not be parsed.
+	Body string `json:"-"`
+}
+
+func newAPIError(res *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: res.StatusCode,
+		RetryAfter: retryAfter(res.Header.Get("Retry-After")),
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err := json.Unmarshal(body, e); err != nil || e.Message == "" && len(e.Errors) == 0 {
+		e.Body = string(body[:min(len(body), 1<<10)])
+	}
+	return e
+}
+
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "status %d", e.StatusCode)
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	} else if e.Body != "" {
+		b.WriteString(": " + e.Body)
+	}
+	for _, field := range e.Fields() {
+		fmt.Fprintf(&b, "; %s: %s", field, strings.Join(e.Errors[field], " "))
+	}
+	return b.String()
+}
+
+// Fields returns the names of the fields Firefly rejected, sorted.
+func (e *APIError) Fields() []string {
+	return slices.Sorted(maps.Keys(e.Errors))
+}
+
+// Split returns the validation messages for fields of the i-th split of a
+// transaction, keyed by field name without the "transactions.i." prefix.
+func (e *APIError) Split(i int) map[string][]string {
+	prefix := "transactions." + strconv.Itoa(i) + "."
+	var m map[string][]string
+	for field, msgs := range e.Errors {
+		if name, ok := strings.CutPrefix(field, prefix); ok {
+			if m == nil {
+				m = make(map[string][]string)
+			}
+			m[name] = msgs
+		}
+	}
+	return m
+}
+
+// Temporary reports whether the request may succeed if retried, as when
+// rate limited or on a server error.
+func (e *APIError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return e.StatusCode >= 500
+}
+
+// Duplicate returns the ID of the transaction group Firefly reports a new
+// transaction duplicates, when created with error_if_duplicate_hash.
+func (e *APIError) Duplicate() (int, bool) {
+	for _, msgs := range e.Errors {
+		for _, msg := range msgs {
+			if rest, ok := strings.CutPrefix(msg, "Duplicate of transaction #"); ok {
+				id, _ := strconv.Atoi(strings.TrimSuffix(rest, "."))
+				return id, true
+			}
+		}
+	}
+	return 0, false
+}
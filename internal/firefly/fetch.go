@@ -2,6 +2,7 @@ package firefly
 
 import (
 	"context"
+	"net/http"
 	"net/url"
 	"os"
 
@@ -20,8 +21,25 @@ func (f Fetch) Run(ctx context.Context, a API) error {
 		q.Add(k, v)
 	}
 	var resp any
-	if err := Do(ctx, a, f.Method, f.Path, q, &resp, nil); err != nil {
-		return err
+	if f.Method != http.MethodGet {
+		if err := Do(ctx, a, f.Method, f.Path, q, &resp, nil); err != nil {
+			return err
+		}
+	} else {
+		for page, err := range Pages[any](ctx, a, f.Path, q) {
+			if err != nil {
+				return err
+			}
+			items, ok := page.([]any)
+			if !ok {
+				resp = page
+				continue
+			}
+			if resp == nil {
+				resp = []any{}
+			}
+			resp = append(resp.([]any), items...)
+		}
 	}
 	if resp == nil {
 		return nil
@@ -0,0 +1,140 @@
+package fireflytest
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/money"
+)
+
+// operator is a single term of a search query, such as -tag_is:gdpr.
+type operator struct {
+	key, value string
+	negate     bool
+}
+
+// parseQuery parses the subset of the Firefly search syntax understood by
+// the fake server. Values may be quoted to include spaces.
+func parseQuery(q string) ([]operator, error) {
+	var ops []operator
+	for _, term := range splitTerms(q) {
+		var op operator
+		term, op.negate = strings.CutPrefix(term, "-")
+		key, value, ok := strings.Cut(term, ":")
+		if !ok {
+			return nil, fmt.Errorf("unsupported search term %q", term)
+		}
+		op.key, op.value = key, strings.Trim(value, `"`)
+		if _, ok := matchers[op.key]; !ok {
+			return nil, fmt.Errorf("unsupported search operator %q", op.key)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func splitTerms(q string) []string {
+	var (
+		terms  []string
+		b      strings.Builder
+		quoted bool
+	)
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case r == ' ' && !quoted:
+			if b.Len() > 0 {
+				terms = append(terms, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		terms = append(terms, b.String())
+	}
+	return terms
+}
+
+var matchers = map[string]func(t firefly.Transaction, value string) bool{
+	"account_id": func(t firefly.Transaction, value string) bool {
+		id, err := strconv.Atoi(value)
+		return err == nil && (int(t.SourceID) == id || int(t.DestinationID) == id)
+	},
+	"date_on": func(t firefly.Transaction, value string) bool {
+		// 2024-01-xx matches any day of the month
+		if month, ok := strings.CutSuffix(value, "-xx"); ok {
+			return t.Date.Format("2006-01") == month
+		}
+		return t.Date.Format(time.DateOnly) == value
+	},
+	// date and amount bounds include the bound itself, as in Firefly
+	"date_after": func(t firefly.Transaction, value string) bool {
+		d, err := time.Parse(time.DateOnly, value)
+		return err == nil && !t.Date.Before(d)
+	},
+	"date_before": func(t firefly.Transaction, value string) bool {
+		d, err := time.Parse(time.DateOnly, value)
+		return err == nil && t.Date.Before(d.AddDate(0, 0, 1))
+	},
+	"amount": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.Amount.Currency)
+		return err == nil && t.Amount.Abs().Units == m.Abs().Units
+	},
+	"amount_more": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.Amount.Currency)
+		return err == nil && t.Amount.Abs().Units >= m.Abs().Units
+	},
+	"amount_less": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.Amount.Currency)
+		return err == nil && t.Amount.Abs().Units <= m.Abs().Units
+	},
+	"foreign_amount_is": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.ForeignAmount.Currency)
+		return err == nil && !t.ForeignAmount.IsZero() && t.ForeignAmount.Abs().Units == m.Abs().Units
+	},
+	"foreign_amount_more": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.ForeignAmount.Currency)
+		return err == nil && !t.ForeignAmount.IsZero() && t.ForeignAmount.Abs().Units >= m.Abs().Units
+	},
+	"foreign_amount_less": func(t firefly.Transaction, value string) bool {
+		m, err := money.Parse(value, t.ForeignAmount.Currency)
+		return err == nil && !t.ForeignAmount.IsZero() && t.ForeignAmount.Abs().Units <= m.Abs().Units
+	},
+	"tag_is": func(t firefly.Transaction, value string) bool {
+		return slices.Contains(t.Tags, value)
+	},
+	"external_id_is": func(t firefly.Transaction, value string) bool {
+		return t.ExternalID == value
+	},
+	"type": func(t firefly.Transaction, value string) bool {
+		return strings.EqualFold(t.Type, value)
+	},
+	"has_any_notes": func(t firefly.Transaction, value string) bool {
+		return (t.Notes != "") == (value == "true")
+	},
+	"description_contains": func(t firefly.Transaction, value string) bool {
+		return strings.Contains(strings.ToLower(t.Description), strings.ToLower(value))
+	},
+}
+
+func init() {
+	matchers["amount_is"] = matchers["amount"]
+}
+
+// match reports whether t satisfies every operator.
+func match(ops []operator, t firefly.Transaction) bool {
+	for _, op := range ops {
+		if matchers[op.key](t, op.value) == op.negate {
+			return false
+		}
+	}
+	return true
+}
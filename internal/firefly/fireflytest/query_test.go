@@ -0,0 +1,60 @@
+package fireflytest
+
+import (
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestMatch(t *testing.T) {
+	tx := firefly.Transaction{
+		Type:          "transfer",
+		Date:          time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Amount:        money.MustParse("12.34", ""),
+		Description:   "Savings",
+		SourceID:      1,
+		DestinationID: 2,
+		Tags:          []string{"gdpr"},
+		ExternalID:    "1001",
+	}
+	for _, tt := range []struct {
+		query string
+		want  bool
+	}{
+		{"account_id:1 date_on:2024-01-02 amount:12.34", true},
+		{"account_id:2", true},
+		{"account_id:3", false},
+		{"date_on:2024-01-xx type:\"Transfer\"", true},
+		{"date_on:2024-01-03", false},
+		{"amount:12.35", false},
+		{"date_after:2024-01-02 date_before:2024-01-02", true},
+		{"date_after:2024-01-03", false},
+		{"date_before:2024-01-01", false},
+		{"amount_more:12.00 amount_less:12.34", true},
+		{"amount_more:12.35", false},
+		{"amount_less:12.33", false},
+		{"foreign_amount_is:12.34", false},
+		{"tag_is:gdpr", true},
+		{"-tag_is:gdpr", false},
+		{"external_id_is:1001", true},
+		{"description_contains:\"sav\"", true},
+		{"has_any_notes:true", false},
+	} {
+		ops, err := parseQuery(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got := match(ops, tx); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryUnsupported(t *testing.T) {
+	if _, err := parseQuery("account_is:Savings"); err == nil {
+		t.Error("expected error for unsupported operator")
+	}
+}
@@ -0,0 +1,586 @@
+// Package fireflytest provides an in-memory fake of the subset of the
+// Firefly III API used by package firefly, for integration tests.
+package fireflytest
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"go.grg.app/gdpr/internal/firefly"
+)
+
+// Server is a fake Firefly server holding its ledger in memory. Groups,
+// journals, accounts and links share a single sequence of IDs.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	groups   map[int]firefly.TransactionGroup
+	accounts map[int]firefly.Account
+	links    map[int]firefly.TransactionLink
+}
+
+// NewServer starts a fake Firefly server with an empty ledger. The caller
+// should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		groups:   make(map[int]firefly.TransactionGroup),
+		accounts: make(map[int]firefly.Account),
+		links:    make(map[int]firefly.TransactionLink),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/about", s.about)
+	mux.HandleFunc("GET /api/v1/search/transactions", s.search)
+	mux.HandleFunc("GET /api/v1/transactions/{id}", s.getTransaction)
+	mux.HandleFunc("POST /api/v1/transactions", s.storeTransaction)
+	mux.HandleFunc("PUT /api/v1/transactions/{id}", s.updateTransaction)
+	mux.HandleFunc("DELETE /api/v1/transactions/{id}", s.deleteTransaction)
+	mux.HandleFunc("GET /api/v1/accounts", s.listAccounts)
+	mux.HandleFunc("POST /api/v1/accounts", s.storeAccount)
+	mux.HandleFunc("GET /api/v1/accounts/{id}", s.getAccount)
+	mux.HandleFunc("GET /api/v1/accounts/{id}/transactions", s.accountTransactions)
+	mux.HandleFunc("GET /api/v1/link-types", s.listLinkTypes)
+	mux.HandleFunc("GET /api/v1/transaction-links", s.listLinks)
+	mux.HandleFunc("GET /api/v1/transaction-journals/{id}/links", s.journalLinks)
+	mux.HandleFunc("POST /api/v1/transaction-links", s.storeLink)
+	mux.HandleFunc("DELETE /api/v1/transaction-links/{id}", s.deleteLink)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// API returns an API configured to make requests to s.
+func (s *Server) API() firefly.API {
+	u, _ := url.Parse(s.URL)
+	return firefly.API{Endpoint: u, Token: "fireflytest"}
+}
+
+func (s *Server) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+// AddAccount adds an account to the ledger and returns its ID. Once any
+// account exists, transactions must refer to existing accounts.
+func (s *Server) AddAccount(a firefly.Account) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.id()
+	s.accounts[id] = a
+	return id
+}
+
+// AddTransaction adds a transaction group to the ledger without validation,
+// assigning IDs to its journals, and returns the group ID.
+func (s *Server) AddTransaction(g firefly.TransactionGroup) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(g)
+}
+
+func (s *Server) add(g firefly.TransactionGroup) int {
+	id := s.id()
+	g.Transactions = slices.Clone(g.Transactions)
+	for i := range g.Transactions {
+		g.Transactions[i].ID = firefly.StringInt(s.id())
+	}
+	s.groups[id] = g
+	return id
+}
+
+// Transaction returns the transaction group with the given ID.
+func (s *Server) Transaction(id int) (firefly.TransactionGroup, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[id]
+	return g, ok
+}
+
+// Transactions returns the IDs of every transaction group, in ascending
+// order.
+func (s *Server) Transactions() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.groups))
+}
+
+// Links returns every transaction link, in the order they were created.
+func (s *Server) Links() []firefly.TransactionLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var links []firefly.TransactionLink
+	for _, id := range slices.Sorted(maps.Keys(s.links)) {
+		links = append(links, s.links[id])
+	}
+	return links
+}
+
+func (s *Server) about(w http.ResponseWriter, r *http.Request) {
+	writeData(w, firefly.About{Version: "6.2.0", APIVersion: "2.1.0"})
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	ops, err := parseQuery(r.URL.Query().Get("query"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	s.mu.Lock()
+	var found []firefly.Object[firefly.TransactionGroup]
+	for _, id := range slices.Sorted(maps.Keys(s.groups)) {
+		g := s.groups[id]
+		if slices.ContainsFunc(g.Transactions, func(t firefly.Transaction) bool { return match(ops, t) }) {
+			found = append(found, object(id, g))
+		}
+	}
+	s.mu.Unlock()
+	paginate(w, r, found)
+}
+
+func (s *Server) getTransaction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	g, ok := s.Transaction(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found", nil)
+		return
+	}
+	writeData(w, object(id, g))
+}
+
+func (s *Server) storeTransaction(w http.ResponseWriter, r *http.Request) {
+	var g firefly.TransactionGroup
+	if err := json.UnmarshalRead(r.Body, &g); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errs := s.validate(g); len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", errs)
+		return
+	}
+	if dup, ok := s.duplicate(g); g.ErrorIfDuplicateHash && ok {
+		writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", map[string][]string{
+			"transactions.0.description": {fmt.Sprintf("Duplicate of transaction #%d.", dup)},
+		})
+		return
+	}
+	id := s.add(g)
+	writeData(w, object(id, s.groups[id]))
+}
+
+func (s *Server) updateTransaction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	var body struct {
+		GroupTitle   string           `json:"group_title"`
+		Transactions []jsontext.Value `json:"transactions"`
+	}
+	if err := json.UnmarshalRead(r.Body, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found", nil)
+		return
+	}
+	g.Transactions = slices.Clone(g.Transactions)
+	if body.GroupTitle != "" {
+		g.GroupTitle = body.GroupTitle
+	}
+	for i, update := range body.Transactions {
+		var ref struct {
+			ID firefly.StringInt `json:"transaction_journal_id"`
+		}
+		json.Unmarshal(update, &ref)
+		j := slices.IndexFunc(g.Transactions, func(t firefly.Transaction) bool { return t.ID == ref.ID })
+		if j < 0 && len(g.Transactions) == 1 && len(body.Transactions) == 1 {
+			j = 0
+		}
+		if j < 0 {
+			writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", map[string][]string{
+				fmt.Sprintf("transactions.%d.transaction_journal_id", i): {"This value is invalid for this field."},
+			})
+			return
+		}
+		merged, err := merge(g.Transactions[j], update)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		merged.ID = g.Transactions[j].ID
+		g.Transactions[j] = merged
+	}
+	if errs := s.validate(g); len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", errs)
+		return
+	}
+	s.groups[id] = g
+	writeData(w, object(id, g))
+}
+
+// merge applies the fields present in update to t, leaving others as they
+// were, as Firefly does when updating a journal.
+func merge(t firefly.Transaction, update jsontext.Value) (firefly.Transaction, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(update, &fields); err != nil {
+		return t, err
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		return t, err
+	}
+	var merged firefly.Transaction
+	err = json.Unmarshal(b, &merged)
+	return merged, err
+}
+
+func (s *Server) deleteTransaction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[id]; !ok {
+		writeError(w, http.StatusNotFound, "Resource not found", nil)
+		return
+	}
+	delete(s.groups, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validate returns the validation errors Firefly would report for g, keyed
+// by field.
+func (s *Server) validate(g firefly.TransactionGroup) map[string][]string {
+	errs := make(map[string][]string)
+	invalid := func(i int, field, msg string) {
+		key := fmt.Sprintf("transactions.%d.%s", i, field)
+		errs[key] = append(errs[key], msg)
+	}
+	if len(g.Transactions) == 0 {
+		errs["transactions"] = []string{"Need at least one transaction."}
+	}
+	if len(g.Transactions) > 1 && g.GroupTitle == "" {
+		errs["group_title"] = []string{"The group title is mandatory when there is more than one split."}
+	}
+	for i, t := range g.Transactions {
+		switch t.Type {
+		case "withdrawal", "deposit", "transfer", "reconciliation":
+		default:
+			invalid(i, "type", "This value is invalid for this field.")
+		}
+		if t.Description == "" {
+			invalid(i, "description", "The description field is required.")
+		}
+		if t.Date.IsZero() {
+			invalid(i, "date", "The date field is required.")
+		}
+		if t.Amount.Sign() <= 0 {
+			invalid(i, "amount", "The amount must be more than zero.")
+		}
+		for field, account := range map[string]struct {
+			id   firefly.StringInt
+			name string
+		}{
+			"source_id":      {t.SourceID, t.Source},
+			"destination_id": {t.DestinationID, t.Destination},
+		} {
+			if account.id == 0 && account.name == "" {
+				// Firefly supplies the reconciliation account
+				if t.Type != "reconciliation" {
+					invalid(i, field, "This value is invalid for this field.")
+				}
+			} else if _, ok := s.accounts[int(account.id)]; account.id != 0 && len(s.accounts) > 0 && !ok {
+				invalid(i, field, "This value is invalid for this field.")
+			}
+		}
+	}
+	return errs
+}
+
+// duplicate returns the ID of a group whose first split has the same type,
+// date, amount, description, accounts and external ID as g's, standing in
+// for Firefly's hash of the whole group.
+func (s *Server) duplicate(g firefly.TransactionGroup) (int, bool) {
+	if len(g.Transactions) == 0 {
+		return 0, false
+	}
+	key := func(t firefly.Transaction) string {
+		return fmt.Sprint(t.Type, t.Date.Format(time.DateOnly), t.Amount, t.Description, t.SourceID, t.Source, t.DestinationID, t.Destination, t.ExternalID)
+	}
+	want := key(g.Transactions[0])
+	for _, id := range slices.Sorted(maps.Keys(s.groups)) {
+		if ts := s.groups[id].Transactions; len(ts) > 0 && key(ts[0]) == want {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// listAccounts lists the accounts of the type given by the type query
+// parameter, or all accounts.
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request) {
+	accountType := r.URL.Query().Get("type")
+	s.mu.Lock()
+	var accounts []firefly.Object[firefly.Account]
+	for _, id := range slices.Sorted(maps.Keys(s.accounts)) {
+		if a := s.accounts[id]; accountType == "" || accountType == "all" || a.Type == accountType {
+			accounts = append(accounts, firefly.Object[firefly.Account]{Type: "accounts", ID: firefly.StringInt(id), Attributes: a})
+		}
+	}
+	s.mu.Unlock()
+	paginate(w, r, accounts)
+}
+
+// getAccount returns an account with its current balance: its opening
+// balance plus the transactions on or before the date query parameter, if
+// given.
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	end := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if date := r.URL.Query().Get("date"); date != "" {
+		d, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		end = d.AddDate(0, 0, 1)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found", nil)
+		return
+	}
+	a.CurrentBalance = a.OpeningBalance
+	for _, g := range s.groups {
+		for _, t := range g.Transactions {
+			switch {
+			case !t.Date.Before(end):
+			case int(t.SourceID) == id:
+				a.CurrentBalance = a.CurrentBalance.Sub(t.Amount.Abs())
+			case int(t.DestinationID) == id:
+				a.CurrentBalance = a.CurrentBalance.Add(t.Amount.Abs())
+			}
+		}
+	}
+	a.CurrentBalanceDate = end.AddDate(0, 0, -1)
+	writeData(w, firefly.Object[firefly.Account]{Type: "accounts", ID: firefly.StringInt(id), Attributes: a})
+}
+
+// accountTransactions lists the transaction groups with a split to or from
+// an account dated between the start and end query parameters, inclusive.
+func (s *Server) accountTransactions(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	q := r.URL.Query()
+	start, _ := time.Parse(time.DateOnly, q.Get("start"))
+	end, err := time.Parse(time.DateOnly, q.Get("end"))
+	if err != nil {
+		end = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	s.mu.Lock()
+	var found []firefly.Object[firefly.TransactionGroup]
+	for _, gid := range slices.Sorted(maps.Keys(s.groups)) {
+		g := s.groups[gid]
+		if slices.ContainsFunc(g.Transactions, func(t firefly.Transaction) bool {
+			return (int(t.SourceID) == id || int(t.DestinationID) == id) && !t.Date.Before(start) && t.Date.Before(end.AddDate(0, 0, 1))
+		}) {
+			found = append(found, object(gid, g))
+		}
+	}
+	s.mu.Unlock()
+	paginate(w, r, found)
+}
+
+func (s *Server) storeAccount(w http.ResponseWriter, r *http.Request) {
+	var a firefly.Account
+	if err := json.UnmarshalRead(r.Body, &a); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	errs := make(map[string][]string)
+	if a.Name == "" {
+		errs["name"] = []string{"The name field is required."}
+	}
+	switch a.Type {
+	case "asset", "expense", "revenue", "liability":
+	default:
+		errs["type"] = []string{"This value is invalid for this field."}
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", errs)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.id()
+	s.accounts[id] = a
+	writeData(w, firefly.Object[firefly.Account]{Type: "accounts", ID: firefly.StringInt(id), Attributes: a})
+}
+
+// listLinkTypes lists the link types Firefly is installed with, which are
+// numbered apart from the ledger.
+func (s *Server) listLinkTypes(w http.ResponseWriter, r *http.Request) {
+	var types []firefly.Object[firefly.LinkType]
+	for i, t := range []firefly.LinkType{
+		{Name: "Related", Inward: "relates to", Outward: "relates to"},
+		{Name: "Refund", Inward: "is (partially) refunded by", Outward: "(partially) refunds"},
+		{Name: "Paid", Inward: "is (partially) paid for by", Outward: "(partially) pays for"},
+		{Name: "Reimbursement", Inward: "is (partially) reimbursed by", Outward: "(partially) reimburses"},
+	} {
+		types = append(types, firefly.Object[firefly.LinkType]{Type: "link_types", ID: firefly.StringInt(i + 1), Attributes: t})
+	}
+	paginate(w, r, types)
+}
+
+func (s *Server) listLinks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var links []firefly.Object[firefly.TransactionLink]
+	for _, id := range slices.Sorted(maps.Keys(s.links)) {
+		links = append(links, firefly.Object[firefly.TransactionLink]{Type: "transaction_links", ID: firefly.StringInt(id), Attributes: s.links[id]})
+	}
+	s.mu.Unlock()
+	paginate(w, r, links)
+}
+
+func (s *Server) journalLinks(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	s.mu.Lock()
+	var links []firefly.Object[firefly.TransactionLink]
+	for _, lid := range slices.Sorted(maps.Keys(s.links)) {
+		if l := s.links[lid]; int(l.InwardID) == id || int(l.OutwardID) == id {
+			links = append(links, firefly.Object[firefly.TransactionLink]{Type: "transaction_links", ID: firefly.StringInt(lid), Attributes: l})
+		}
+	}
+	s.mu.Unlock()
+	paginate(w, r, links)
+}
+
+func (s *Server) storeLink(w http.ResponseWriter, r *http.Request) {
+	var l firefly.TransactionLink
+	if err := json.UnmarshalRead(r.Body, &l); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make(map[string][]string)
+	for field, id := range map[string]firefly.StringInt{"inward_id": l.InwardID, "outward_id": l.OutwardID} {
+		if !s.journalExists(int(id)) {
+			errs[field] = []string{"This value is invalid for this field."}
+		}
+	}
+	for _, existing := range s.links {
+		if existing.InwardID == l.InwardID && existing.OutwardID == l.OutwardID {
+			errs["outward_id"] = append(errs["outward_id"], "Already have a link between inward and outward.")
+		}
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "The given data was invalid.", errs)
+		return
+	}
+	id := s.id()
+	s.links[id] = l
+	writeData(w, firefly.Object[firefly.TransactionLink]{Type: "transaction_links", ID: firefly.StringInt(id), Attributes: l})
+}
+
+func (s *Server) deleteLink(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.links[id]; !ok {
+		writeError(w, http.StatusNotFound, "Resource not found", nil)
+		return
+	}
+	delete(s.links, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) journalExists(id int) bool {
+	for _, g := range s.groups {
+		if slices.ContainsFunc(g.Transactions, func(t firefly.Transaction) bool { return int(t.ID) == id }) {
+			return true
+		}
+	}
+	return false
+}
+
+func object(id int, g firefly.TransactionGroup) firefly.Object[firefly.TransactionGroup] {
+	return firefly.Object[firefly.TransactionGroup]{Type: "transactions", ID: firefly.StringInt(id), Attributes: g}
+}
+
+// paginate writes the page of items selected by the page and limit query
+// parameters, with the pagination metadata and links Firefly includes.
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	start, end := min((page-1)*limit, len(items)), min(page*limit, len(items))
+	var resp struct {
+		Data []T `json:"data"`
+		Meta struct {
+			Pagination firefly.Pagination `json:"pagination"`
+		} `json:"meta"`
+		Links map[string]string `json:"links"`
+	}
+	resp.Data = items[start:end]
+	if resp.Data == nil {
+		resp.Data = []T{}
+	}
+	resp.Meta.Pagination = firefly.Pagination{
+		Total:       len(items),
+		Count:       end - start,
+		PerPage:     limit,
+		CurrentPage: page,
+		TotalPages:  max((len(items)+limit-1)/limit, 1),
+	}
+	resp.Links = make(map[string]string)
+	if page < resp.Meta.Pagination.TotalPages {
+		q.Set("page", strconv.Itoa(page+1))
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+		resp.Links["next"] = next.String()
+	}
+	write(w, http.StatusOK, resp)
+}
+
+func writeData[T any](w http.ResponseWriter, v T) {
+	write(w, http.StatusOK, struct {
+		Data T `json:"data"`
+	}{v})
+}
+
+func writeError(w http.ResponseWriter, status int, message string, errs map[string][]string) {
+	write(w, status, struct {
+		Message string              `json:"message"`
+		Errors  map[string][]string `json:"errors,omitzero"`
+	}{message, errs})
+}
+
+func write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	json.MarshalWrite(w, v)
+}
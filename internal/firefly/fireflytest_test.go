@@ -0,0 +1,64 @@
+package firefly_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+)
+
+// newMatch returns a match of csv, rows of a date, description and amount
+// from account 1, tagging what it matches with gdpr and keeping the
+// descriptions in Firefly. Its journal is kept in a directory of the test.
+func newMatch(t *testing.T, csv string) firefly.Match {
+	return firefly.Match{
+		AccountID:       1,
+		File:            []byte(csv),
+		KeepDescription: true,
+		Tag:             "gdpr",
+		ColDate:         1,
+		DateFormat:      "02 Jan 06",
+		ColDescription:  2,
+		ColAmount:       3,
+		Journal:         t.TempDir(),
+	}
+}
+
+// seed adds each of trs to s as a transaction of its own, returning their
+// IDs. Transactions without a type are withdrawals, and those without
+// accounts are from account 1 to account 5.
+func seed(s *fireflytest.Server, trs ...firefly.Transaction) []int {
+	ids := make([]int, len(trs))
+	for i, tr := range trs {
+		if tr.Type == "" {
+			tr.Type = "withdrawal"
+		}
+		if tr.SourceID == 0 && tr.DestinationID == 0 {
+			tr.SourceID, tr.DestinationID = 1, 5
+		}
+		ids[i] = s.AddTransaction(firefly.TransactionGroup{Transactions: []firefly.Transaction{tr}})
+	}
+	return ids
+}
+
+// stdout returns what f writes to os.Stdout.
+func stdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	err = f()
+	w.Close()
+	return <-done, err
+}
@@ -0,0 +1,135 @@
+package firefly
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Import creates the transactions of a statement missing from Firefly.
+// Each row is given an external ID derived from its account, date,
+// description and amount, so importing a statement again, or an
+// overlapping one, skips the rows already imported.
+type Import struct {
+	File       []byte `arg:"" type:"filecontent" help:"CSV file of Account,Date,Description,Payments,Receipts,Running rows, as written by barclays and reexport"`
+	AccountID  int    `short:"a" help:"Asset account ID of every row, overriding the Account column"`
+	AssetIDs   []int  `name:"assets" help:"Asset account IDs create transfers"`
+	Mapping    string `help:"JSON file of rules mapping descriptions to opposing accounts, categories and tags" type:"existingfile"`
+	Unmapped   string `help:"Rows no rule maps: skip them, or create them with an opposing account named after the description" enum:"skip,name" default:"skip"`
+	Tag        string `help:"Tag to apply to created transactions" default:"gdpr"`
+	ApplyRules bool   `help:"Apply Firefly's rules to created transactions"`
+}
+
+func (im Import) Run(ctx context.Context, a API) error {
+	mapping, err := LoadMapping(im.Mapping)
+	if err != nil {
+		return err
+	}
+	st, err := readStatement(ctx, a, im.File, im.AccountID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		// seen counts identical rows, which are distinct transactions
+		seen                                      = make(map[string]int)
+		created, existing, unmapped, failed, rows int
+	)
+	for row := 2; ; row++ {
+		record, err := st.Read()
+		if err == io.EOF {
+			break
+		}
+		l := slog.With(slog.Int("row", row))
+		if err != nil {
+			l.Warn("skipping record", slog.String("err", err.Error()))
+			failed++
+			continue
+		}
+		rows++
+		li, err := st.parse(row, record)
+		if err != nil {
+			l.Warn("invalid row", slog.String("err", err.Error()), slog.String("record", strings.Join(record, ",")))
+			failed++
+			continue
+		}
+
+		account, err := st.accountOf(record)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+
+		key := importKey(account, li)
+		externalID := fmt.Sprintf("gdpr-%s-%d", key, seen[key])
+		seen[key]++
+		found, err := Collect(a.SearchTransactions(ctx, "external_id_is:"+externalID))
+		if err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			l.Info("already imported", slog.Int("id", int(found[0].ID)))
+			existing++
+			continue
+		}
+
+		rule, i := mapping.Match(li.description)
+		if i < 0 && im.Unmapped == "skip" {
+			l.Info("no rule maps row", slog.String("description", li.description))
+			unmapped++
+			continue
+		}
+		g, err := newTransaction(li, account, rule.AccountID, im.AssetIDs, rule, rule.Splits, im.Tag)
+		if err != nil {
+			l.Warn("invalid splits", slog.String("rule", rule.String()), slog.String("err", err.Error()))
+			failed++
+			continue
+		}
+		g.ErrorIfDuplicateHash = true
+		g.ApplyRules = im.ApplyRules
+		for i := range g.Transactions {
+			g.Transactions[i].ExternalID = externalID
+		}
+
+		out, err := a.CreateTransaction(ctx, g)
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr):
+			if id, ok := apiErr.Duplicate(); ok {
+				l.Info("duplicate", slog.Int("id", id))
+				existing++
+				continue
+			}
+			l.Error("rejected", slog.String("err", err.Error()))
+			failed++
+		case err != nil:
+			return err
+		default:
+			l.Info("created", slog.Int("id", int(out.ID)), slog.String("description", li.description))
+			created++
+		}
+	}
+
+	fmt.Printf("%d rows: %d created, %d already in Firefly, %d unmapped, %d failed\n", rows, created, existing, unmapped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d rows failed", failed)
+	}
+	return nil
+}
+
+// importKey identifies the transaction of li in account.
+func importKey(account int, li line) string {
+	amount := li.amount.String()
+	if li.payment {
+		amount = "-" + amount
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		strconv.Itoa(account), li.date.Format("2006-01-02"), li.description, amount,
+	}, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
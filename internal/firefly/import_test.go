@@ -0,0 +1,67 @@
+package firefly_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+)
+
+func TestImport(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	current := s.AddAccount(firefly.Account{Name: "Current", Type: "asset", AccountNumber: "12345678"})
+	tesco := s.AddAccount(firefly.Account{Name: "Tesco", Type: "expense"})
+	employer := s.AddAccount(firefly.Account{Name: "Employer", Type: "revenue"})
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(path, fmt.Appendf(nil, `{"rules": [
+		{"contains": "TESCO", "account_id": %d, "category": "Groceries"},
+		{"exact": "SALARY", "account_id": %d}
+	]}`, tesco, employer), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im := firefly.Import{
+		File: []byte(`Account,Date,Description,Payments,Receipts,Running
+20-00-00 12345678,01 Jan 24,TESCO STORES,12.34,,87.66
+20-00-00 12345678,01 Jan 24,TESCO STORES,12.34,,75.32
+20-00-00 12345678,02 Jan 24,SALARY,,1000.00,1075.32
+20-00-00 12345678,03 Jan 24,CORNER SHOP,2.00,,1073.32
+`),
+		Mapping:  path,
+		Unmapped: "skip",
+		Tag:      "gdpr",
+	}
+	for range 2 {
+		if err := im.Run(t.Context(), s.API()); err != nil {
+			t.Fatal(err)
+		}
+		if ids := s.Transactions(); len(ids) != 3 {
+			t.Fatalf("got %d transactions, want 3", len(ids))
+		}
+	}
+	ids := s.Transactions()
+	first, _ := s.Transaction(ids[0])
+	second, _ := s.Transaction(ids[1])
+	if a, b := first.Transactions[0], second.Transactions[0]; a.ExternalID == b.ExternalID || int(a.SourceID) != current || int(a.DestinationID) != tesco || a.Category != "Groceries" {
+		t.Errorf("created %+v and %+v", a, b)
+	}
+	salary, _ := s.Transaction(ids[2])
+	if tr := salary.Transactions[0]; tr.Type != "deposit" || int(tr.SourceID) != employer || int(tr.DestinationID) != current {
+		t.Errorf("created %+v", tr)
+	}
+
+	im.Unmapped = "name"
+	if err := im.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	ids = s.Transactions()
+	shop, _ := s.Transaction(ids[len(ids)-1])
+	if tr := shop.Transactions[0]; len(ids) != 4 || tr.Destination != "CORNER SHOP" || int(tr.SourceID) != current {
+		t.Errorf("created %+v", tr)
+	}
+}
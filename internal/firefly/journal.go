@@ -0,0 +1,132 @@
+package firefly
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-json-experiment/json"
+)
+
+const (
+	outcomeMatched = "matched"
+	outcomeCreated = "created"
+	outcomeSkipped = "skipped"
+)
+
+// entry is the outcome of a single row of a match session.
+type entry struct {
+	Row     int       `json:"row"`
+	Outcome string    `json:"outcome"`
+	ID      int       `json:"id,omitzero"`
+	Reason  string    `json:"reason,omitzero"`
+	Time    time.Time `json:"time"`
+}
+
+// journal records the outcome of each row of a match session in a file
+// named by a hash of the input, so a rerun with the same input resumes where
+// the last left off. A nil journal records nothing.
+//
+// Rows matched or created are also recorded to undo, if set, so undoing the
+// session undoes them in the journal.
+type journal struct {
+	path string
+	f    *os.File
+	rows map[int]entry
+	undo *UndoLog
+}
+
+// openJournal opens the journal for input in dir, reading the outcomes
+// already recorded. It returns nil if dir is empty.
+func openJournal(dir string, input []byte) (*journal, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(input)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])+".jsonl")
+	j := &journal{path: path, rows: make(map[int]entry)}
+	if f, err := os.Open(path); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var e entry
+			if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("journal %s: %w", path, err)
+			}
+			j.rows[e.Row] = e
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var err error
+	j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	return j, err
+}
+
+// done returns the recorded outcome of row if it was matched or created.
+// Skipped rows are not done, so they are attempted again.
+func (j *journal) done(row int) (entry, bool) {
+	if j == nil {
+		return entry{}, false
+	}
+	e, ok := j.rows[row]
+	return e, ok && e.Outcome != outcomeSkipped
+}
+
+func (j *journal) record(e entry) error {
+	if j == nil {
+		return nil
+	}
+	e.Time = time.Now()
+	j.rows[e.Row] = e
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if j.undo == nil || e.Outcome == outcomeSkipped {
+		return nil
+	}
+	return j.undo.record(reversal{Journal: j.path, Row: e.Row})
+}
+
+// undoJournal records rows of the journal at path as undone, so a rerun
+// attempts them again. A journal since removed has nothing to resume.
+func undoJournal(path string, rows []int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	j := &journal{path: path, f: f, rows: make(map[int]entry)}
+	for _, row := range rows {
+		if err := j.record(entry{Row: row, Outcome: outcomeSkipped, Reason: "undone"}); err != nil {
+			j.Close()
+			return err
+		}
+	}
+	return j.Close()
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
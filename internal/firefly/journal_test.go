@@ -0,0 +1,42 @@
+package firefly
+
+import "testing"
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	input := []byte("01 Jan 24,TESCO,-1.00\n")
+	j, err := openJournal(dir, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []entry{
+		{Row: 1, Outcome: outcomeMatched, ID: 10},
+		{Row: 2, Outcome: outcomeSkipped, Reason: "no transaction found"},
+		{Row: 3, Outcome: outcomeCreated, ID: 11},
+	} {
+		if err := j.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	j, err = openJournal(dir, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	for row, want := range map[int]bool{1: true, 2: false, 3: true, 4: false} {
+		if _, done := j.done(row); done != want {
+			t.Errorf("row %d: got done %v, want %v", row, done, want)
+		}
+	}
+
+	other, err := openJournal(dir, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, done := other.done(1); done {
+		t.Error("journal shared between different inputs")
+	}
+}
@@ -1,95 +1,150 @@
 package firefly
 
 import (
-	"bytes"
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
-	"github.com/go-json-experiment/json"
+	"go.grg.app/gdpr/internal/money"
 )
 
+// linkNotes is the default grammar of the notes Link reads: the share of
+// the transaction reimbursed, then the external IDs of the transactions
+// reimbursing it.
+const linkNotes = `^(?P<ratio>\S+)\s+(?P<ids>.+)$`
+
+// Link links transactions whose notes give the share reimbursed and the
+// external IDs of the reimbursing transactions, such as "0.5 1001|1002",
+// to those transactions.
 type Link struct {
-	Query string `short:"q" help:"Query for transactions containing notes" required:""`
-	Input []byte `short:"i" help:"Substitute input" hidden:"" type:"filecontent"`
+	Query     string `short:"q" help:"Query for transactions containing notes" required:""`
+	Type      string `help:"Name of the link type to create (default Paid)"`
+	Notes     string `help:"Regular expression matching notes, with groups named ratio and ids (default ^(?P<ratio>\\S+)\\s+(?P<ids>.+)$)"`
+	Separator string `help:"Separator of the external IDs in the ids group (default |)"`
 }
 
 func (l Link) Run(ctx context.Context, a API) error {
-	q := make(url.Values, 1)
-	q.Add("query", l.Query)
-	q.Add("limit", "65536")
-
-	var resp []struct {
-		Attributes struct {
-			Transactions []struct {
-				ID         StringInt `json:"transaction_journal_id"`
-				ExternalID StringInt `json:"external_id"`
-				Notes      string    `json:"notes"`
-			} `json:"transactions"`
-		} `json:"attributes"`
+	notes, err := regexp.Compile(cmp.Or(l.Notes, linkNotes))
+	if err != nil {
+		return fmt.Errorf("notes pattern: %w", err)
 	}
-	if len(l.Input) > 0 {
-		ctx = context.WithValue(ctx, OverrideReaderContextKey, bytes.NewReader(l.Input))
+	ratioGroup, idsGroup := notes.SubexpIndex("ratio"), notes.SubexpIndex("ids")
+	if ratioGroup < 0 || idsGroup < 0 {
+		return errors.New("notes pattern must have groups named ratio and ids")
 	}
-	if err := Do(ctx, a, "search/transactions", http.MethodGet, q, &resp, nil); err != nil {
+	linkType, err := l.linkType(ctx, a)
+	if err != nil {
 		return err
 	}
-	ctx = context.WithValue(ctx, OverrideReaderContextKey, nil)
 
-	for _, r := range resp {
+	var created, skipped, failed int
+	for r, err := range a.SearchTransactions(ctx, l.Query) {
+		if err != nil {
+			return err
+		}
 		for _, t := range r.Attributes.Transactions {
-			lhs, note, ok := strings.Cut(t.Notes, " ")
-			if !ok {
+			m := notes.FindStringSubmatch(t.Notes)
+			if m == nil {
 				continue
 			}
-			if lhs == "0.0" {
-				slog.Warn("expected 0% split", slog.Int("id", int(t.ID)), slog.String("note", note))
+			ratio, err := strconv.ParseFloat(strings.TrimSpace(m[ratioGroup]), 64)
+			if err != nil || ratio < 0 || ratio > 1 || math.IsNaN(ratio) {
+				slog.Error("ratio not a number from 0 to 1", slog.Int("id", int(t.ID)), slog.String("ratio", m[ratioGroup]))
+				failed++
+				continue
+			}
+			if ratio == 0 {
+				slog.Warn("expected 0% split", slog.Int("id", int(t.ID)), slog.String("note", t.Notes))
 			}
-			note = strings.TrimSpace(note)
-			for dst := range strings.SplitSeq(note, "|") {
+			var dsts []string
+			for dst := range strings.SplitSeq(m[idsGroup], cmp.Or(l.Separator, "|")) {
+				if dst = strings.TrimSpace(dst); dst != "" {
+					dsts = append(dsts, dst)
+				}
+			}
+			// the share reimbursed is split evenly between the reimbursing
+			// transactions
+			share := scale(t.Amount.Abs(), ratio/float64(len(dsts)))
+			existing, err := Collect(a.JournalLinks(ctx, int(t.ID)))
+			if err != nil {
+				return err
+			}
+			for _, dst := range dsts {
 				slog.Info("link", slog.Int("id", int(t.ID)), slog.String("destination external", dst))
 
-				var resp []struct {
-					Attributes struct {
-						Transactions []struct {
-							ID StringInt `json:"transaction_journal_id"`
-						} `json:"transactions"`
-					} `json:"attributes"`
-				}
-				q := make(url.Values, 1)
-				q.Add("query", `external_id_is:`+dst)
-				q.Add("limit", "1")
-				if err := Do(ctx, a, "search/transactions", http.MethodGet, q, &resp, nil); err != nil {
-					return err
+				var target Object[TransactionGroup]
+				for r, err := range a.SearchTransactions(ctx, `external_id_is:`+dst) {
+					if err != nil {
+						return err
+					}
+					target = r
+					break
 				}
-				if len(resp) == 0 {
+				if len(target.Attributes.Transactions) == 0 {
 					slog.Error("no transaction found", slog.Int("id", int(t.ID)), slog.String("destination external", dst))
+					failed++
 					continue
 				}
+				reimbursing := target.Attributes.Transactions[0]
+				fmt.Printf("%d %q reimburses %s of %d %q (%s × %g / %d), and is %s\n",
+					reimbursing.ID, reimbursing.Description, share, t.ID, t.Description, t.Amount.Abs(), ratio, len(dsts), reimbursing.Amount.Abs())
 
-				p, err := json.Marshal(struct {
-					Type int `json:"link_type_id"`
-					From int `json:"inward_id"`
-					To   int `json:"outward_id"`
-				}{
-					Type: 3,
-					From: int(resp[0].Attributes.Transactions[0].ID),
-					To:   int(t.ID),
-				})
-				if err != nil {
-					return err
+				link := TransactionLink{
+					LinkTypeID: StringInt(linkType),
+					InwardID:   reimbursing.ID,
+					OutwardID:  t.ID,
+				}
+				if slices.ContainsFunc(existing, func(o Object[TransactionLink]) bool { return o.Attributes.same(link) }) {
+					slog.Info("already linked", slog.Int("from", int(link.InwardID)), slog.Int("to", int(link.OutwardID)))
+					skipped++
+					continue
 				}
-				var out any
-				slog.Info("creating link", slog.String("payload", string(p)))
-				if err := Do(ctx, a, http.MethodPost, "transaction-links", nil, &out, bytes.NewReader(p)); err != nil {
+				slog.Info("creating link", slog.Int("from", int(link.InwardID)), slog.Int("to", int(link.OutwardID)))
+				o, err := a.CreateTransactionLink(ctx, link)
+				if err != nil {
 					slog.Error("failed to create link", slog.Int("id", int(t.ID)), slog.String("err", err.Error()))
+					failed++
 					continue
 				}
+				existing = append(existing, o)
+				created++
 			}
 		}
 	}
 
+	fmt.Printf("%d links created, %d already linked, %d failed\n", created, skipped, failed)
 	return nil
 }
+
+// same reports whether l and o link the same journals the same way.
+func (l TransactionLink) same(o TransactionLink) bool {
+	return l.LinkTypeID == o.LinkTypeID && l.InwardID == o.InwardID && l.OutwardID == o.OutwardID
+}
+
+// linkType returns the ID of the link type named l.Type, ignoring case.
+func (l Link) linkType(ctx context.Context, a API) (int, error) {
+	name := cmp.Or(l.Type, "Paid")
+	var names []string
+	for o, err := range a.LinkTypes(ctx) {
+		if err != nil {
+			return 0, err
+		}
+		if strings.EqualFold(o.Attributes.Name, name) {
+			return int(o.ID), nil
+		}
+		names = append(names, o.Attributes.Name)
+	}
+	return 0, fmt.Errorf("no link type named %q in %s", name, strings.Join(names, ", "))
+}
+
+// scale returns m multiplied by f, rounded to the nearest unit.
+func scale(m money.Money, f float64) money.Money {
+	return money.New(int64(math.Round(float64(m.Units)*f)), m.Currency)
+}
@@ -0,0 +1,78 @@
+package firefly_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestLinkLedger(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	seed(s,
+		firefly.Transaction{Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("30.00", ""), Description: "Dinner", Notes: "0.5 1001"},
+		firefly.Transaction{Type: "deposit", Date: time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("15.00", ""), Description: "Repayment", SourceID: 7, DestinationID: 1, ExternalID: "1001"},
+	)
+
+	if err := (firefly.Link{Query: "has_any_notes:true"}).Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	links := s.Links()
+	if len(links) != 1 {
+		t.Fatalf("got %d links, want 1", len(links))
+	}
+	// journals are numbered after their groups: 1/2 and 3/4
+	if links[0].InwardID != 4 || links[0].OutwardID != 2 {
+		t.Errorf("got link %+v, want 4 → 2", links[0])
+	}
+}
+
+func TestLinkNotes(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	trs := []firefly.Transaction{
+		{Amount: money.MustParse("30.00", ""), Description: "Dinner", Notes: "reimbursed 0.75 by 1001, 1002"},
+		{Amount: money.MustParse("8.00", ""), Description: "Taxi", Notes: "reimbursed 1.5 by 1001"},
+		{Type: "deposit", Amount: money.MustParse("11.25", ""), Description: "Ann", SourceID: 7, DestinationID: 1, ExternalID: "1001"},
+		{Type: "deposit", Amount: money.MustParse("11.00", ""), Description: "Bob", SourceID: 8, DestinationID: 1, ExternalID: "1002"},
+	}
+	for i := range trs {
+		trs[i].Date = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	}
+	seed(s, trs...)
+
+	l := firefly.Link{Query: "has_any_notes:true", Type: "reimbursement", Notes: `^reimbursed (?P<ratio>\S+) by (?P<ids>.+)$`, Separator: ","}
+	out, err := stdout(t, func() error { return l.Run(t.Context(), s.API()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`6 "Ann" reimburses 11.25 of 2 "Dinner" (30.00 × 0.75 / 2), and is 11.25`,
+		`8 "Bob" reimburses 11.25 of 2 "Dinner" (30.00 × 0.75 / 2), and is 11.00`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	links := s.Links()
+	if len(links) != 2 || links[0].LinkTypeID != 4 || links[0].InwardID != 6 || links[1].InwardID != 8 {
+		t.Errorf("got links %+v, want two reimbursements of 2", links)
+	}
+	if !strings.Contains(out, "2 links created, 0 already linked, 1 failed") {
+		t.Errorf("output does not count links:\n%s", out)
+	}
+
+	out, err = stdout(t, func() error { return l.Run(t.Context(), s.API()) })
+	if err != nil || !strings.Contains(out, "0 links created, 2 already linked, 1 failed") || len(s.Links()) != 2 {
+		t.Errorf("got %v and %d links rerunning:\n%s", err, len(s.Links()), out)
+	}
+
+	l.Type = "Repaid"
+	if err := l.Run(t.Context(), s.API()); err == nil || !strings.Contains(err.Error(), `no link type named "Repaid"`) {
+		t.Errorf("got %v for an unknown link type", err)
+	}
+}
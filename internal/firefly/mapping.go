@@ -0,0 +1,244 @@
+package firefly
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-json-experiment/json"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+// Mapping is a list of rules mapping statement descriptions to the opposing
+// account of new transactions, in priority order.
+type Mapping struct {
+	Rules []MappingRule `json:"rules"`
+}
+
+// MappingRule matches descriptions containing Contains, matching Regex or
+// equal to Exact, of which exactly one is set. Transactions created for a
+// matching description use AccountID as the opposing account and are given
+// Category, Budget and Tags, and are split by Splits if set.
+type MappingRule struct {
+	Name      string         `json:"name,omitzero"`
+	Contains  string         `json:"contains,omitzero"`
+	Regex     string         `json:"regex,omitzero"`
+	Exact     string         `json:"exact,omitzero"`
+	AccountID int            `json:"account_id"`
+	Category  string         `json:"category,omitzero"`
+	Budget    string         `json:"budget,omitzero"`
+	Tags      []string       `json:"tags,omitzero"`
+	Splits    []MappingSplit `json:"splits,omitzero"`
+
+	re *regexp.Regexp
+}
+
+// MappingSplit is a split of a transaction created by a [MappingRule]. Unset
+// fields are taken from the rule, and at most one split may leave Amount
+// unset to take the remainder of the amount.
+type MappingSplit struct {
+	Description string   `json:"description,omitzero"`
+	Amount      string   `json:"amount,omitzero"`
+	AccountID   int      `json:"account_id,omitzero"`
+	Category    string   `json:"category,omitzero"`
+	Budget      string   `json:"budget,omitzero"`
+	Tags        []string `json:"tags,omitzero"`
+}
+
+// LoadMapping reads a JSON mapping file. An empty path returns an empty
+// mapping.
+func LoadMapping(path string) (Mapping, error) {
+	var m Mapping
+	if path == "" {
+		return m, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("mapping %s: %w", path, err)
+	}
+	var errs error
+	for i := range m.Rules {
+		if err := m.Rules[i].compile(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("mapping %s: rule %d: %w", path, i+1, err))
+		}
+	}
+	return m, errs
+}
+
+func (r *MappingRule) compile() error {
+	set := 0
+	for _, s := range []string{r.Contains, r.Regex, r.Exact} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of contains, regex or exact must be set")
+	}
+	if r.AccountID == 0 {
+		return errors.New("account_id must be set")
+	}
+	if err := checkSplits(r.Splits); err != nil {
+		return err
+	}
+	if r.Regex != "" {
+		var err error
+		r.re, err = regexp.Compile(r.Regex)
+		return err
+	}
+	return nil
+}
+
+func (r MappingRule) matches(description string) bool {
+	switch {
+	case r.Contains != "":
+		return strings.Contains(description, r.Contains)
+	case r.re != nil:
+		return r.re.MatchString(description)
+	default:
+		return strings.TrimSpace(description) == r.Exact
+	}
+}
+
+func (r MappingRule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	switch {
+	case r.Contains != "":
+		return fmt.Sprintf("contains %q", r.Contains)
+	case r.Regex != "":
+		return fmt.Sprintf("regex %q", r.Regex)
+	default:
+		return fmt.Sprintf("exact %q", r.Exact)
+	}
+}
+
+// Match returns the first rule matching description and its index, or -1
+// if none match.
+func (m Mapping) Match(description string) (MappingRule, int) {
+	for i, r := range m.Rules {
+		if r.matches(description) {
+			return r, i
+		}
+	}
+	return MappingRule{}, -1
+}
+
+// splitCurrency is a currency with the most decimal places, for checking
+// split amounts before the currency of the statement is known; amounts
+// checks them against that currency.
+const splitCurrency = "KWD"
+
+func checkSplits(splits []MappingSplit) error {
+	var remainders int
+	for _, s := range splits {
+		if s.Amount == "" {
+			remainders++
+		} else if m, err := money.Parse(s.Amount, splitCurrency); err != nil {
+			return err
+		} else if m.Sign() <= 0 {
+			return errors.New("split amounts must be positive")
+		}
+	}
+	if remainders > 1 {
+		return errors.New("at most one split may take the remainder")
+	}
+	return nil
+}
+
+// amounts returns the amount of each split of total, giving the remainder
+// to the split without an amount.
+func amounts(total money.Money, splits []MappingSplit) ([]money.Money, error) {
+	out := make([]money.Money, len(splits))
+	remainder, rest := total, -1
+	for i, s := range splits {
+		if s.Amount == "" {
+			rest = i
+			continue
+		}
+		var err error
+		if out[i], err = money.Parse(s.Amount, total.Currency); err != nil {
+			return nil, err
+		}
+		remainder = remainder.Sub(out[i])
+	}
+	switch {
+	case rest >= 0 && remainder.Sign() > 0:
+		out[rest] = remainder
+	case rest >= 0 || !remainder.IsZero():
+		return nil, fmt.Errorf("splits do not add up to %s", total)
+	}
+	return out, nil
+}
+
+// parseSplits parses splits written as "20.00 Groceries; 5.50 Household;
+// Other", each an optional amount followed by a category.
+func parseSplits(s string) ([]MappingSplit, error) {
+	var splits []MappingSplit
+	for part := range strings.SplitSeq(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var split MappingSplit
+		amount, category, _ := strings.Cut(part, " ")
+		if _, err := money.Parse(amount, splitCurrency); err == nil {
+			split.Amount, split.Category = amount, strings.TrimSpace(category)
+		} else {
+			split.Category = part
+		}
+		splits = append(splits, split)
+	}
+	return splits, checkSplits(splits)
+}
+
+// MappingTest shows which rule of a mapping file matches a description.
+type MappingTest struct {
+	Description string `arg:"" help:"Statement description to test"`
+	Mapping     string `short:"m" required:"" type:"existingfile" help:"JSON file of rules mapping descriptions to opposing accounts"`
+}
+
+func (t MappingTest) Run() error {
+	m, err := LoadMapping(t.Mapping)
+	if err != nil {
+		return err
+	}
+	r, i := m.Match(t.Description)
+	if i < 0 {
+		fmt.Println("no rule matches")
+		return nil
+	}
+	fmt.Printf("rule %d (%s): account %d", i+1, r, r.AccountID)
+	if r.Category != "" {
+		fmt.Printf(", category %q", r.Category)
+	}
+	if r.Budget != "" {
+		fmt.Printf(", budget %q", r.Budget)
+	}
+	if len(r.Tags) > 0 {
+		fmt.Printf(", tags %s", strings.Join(r.Tags, ", "))
+	}
+	fmt.Println()
+	for _, s := range r.Splits {
+		amount := cmp.Or(s.Amount, "remainder")
+		fmt.Printf("\tsplit %s", amount)
+		for _, f := range [][2]string{{"description", s.Description}, {"category", s.Category}, {"budget", s.Budget}} {
+			if f[1] != "" {
+				fmt.Printf(", %s %q", f[0], f[1])
+			}
+		}
+		if s.AccountID != 0 {
+			fmt.Printf(", account %d", s.AccountID)
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package firefly
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestSplitAmounts(t *testing.T) {
+	for _, tt := range []struct {
+		splits, total, currency, want string
+	}{
+		{"20.00 Groceries; 5.50 Household; Other", "30.00", "", "[20.00 5.50 4.50]"},
+		{"10 Books; 15 Stationery", "25.00", "", "[10.00 15.00]"},
+		{"10 Books; 10 Stationery", "25.00", "", "splits do not add up to 25.00"},
+		{"30 Books; Other", "25.00", "", "splits do not add up to 25.00"},
+		{"Books; Other", "25.00", "", "at most one split may take the remainder"},
+		{"1000 Hotel; Other", "1500", "JPY", "[1000 500]"},
+	} {
+		splits, err := parseSplits(tt.splits)
+		var got any = err
+		if err == nil {
+			got, err = amounts(money.MustParse(tt.total, tt.currency), splits)
+			if err != nil {
+				got = err
+			}
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("%s of %s: got %s, want %s", tt.splits, tt.total, s, tt.want)
+		}
+	}
+}
+
+func TestLoadMappingInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(path, []byte(`{"rules": [{"contains": "A", "exact": "A", "account_id": 1}, {"regex": "(", "account_id": 2}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadMapping(path)
+	if err == nil || !strings.Contains(err.Error(), "rule 1") || !strings.Contains(err.Error(), "rule 2") {
+		t.Errorf("got error %v, want errors for rules 1 and 2", err)
+	}
+}
@@ -2,58 +2,153 @@ package firefly
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
+	"maps"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/charmbracelet/bubbles/v2/list"
-	"github.com/charmbracelet/bubbles/v2/textinput"
 	tea "github.com/charmbracelet/bubbletea/v2"
 	"github.com/go-json-experiment/json"
+
+	"go.grg.app/gdpr/internal/csvhead"
+	"go.grg.app/gdpr/internal/money"
 )
 
 type Match struct {
-	AccountID       int    `short:"a" required:""`
-	File            []byte `type:"filecontent" required:""`
-	Start           int    `short:"s" help:"Start at row"`
-	AssetIDs        []int  `name:"assets" help:"Asset account IDs create transfers"`
-	KeepDescription bool   `help:"Keep existing descriptions if set" default:"true"`
-	Tag             string `required:"" help:"Tag to apply to matched transactions" default:"gdpr"`
-	ColDate         int    `required:"" help:"Column number for date, one-indexed"`
-	DateFormat      string `required:"" help:"Format for date column" default:"02 Jan 06"`
-	ColDescription  int    `required:"" help:"Column number for description"`
-	ColAmount       int    `required:"" help:"Column number for amount, +deposit, -withdrawal"`
-	ColWithdrawal   int    `help:"Column number for payment, if applicable"`
-	ApproxTransfer  string `help:"String to find in description to approximately match transfers by month"`
+	AccountID          int         `short:"a" required:""`
+	File               []byte      `type:"filecontent" required:""`
+	Start              int         `short:"s" help:"Start at row"`
+	AssetIDs           []int       `name:"assets" help:"Asset account IDs create transfers"`
+	KeepDescription    bool        `help:"Keep existing descriptions if set" default:"true"`
+	Tag                string      `required:"" help:"Tag to apply to matched transactions" default:"gdpr"`
+	Profile            string      `help:"CSV profile giving the layout of the file: barclays, reexport, monzo or a JSON profile file"`
+	ColDate            int         `help:"Column number for date, one-indexed, overriding the profile"`
+	DateFormat         string      `help:"Format for date column, overriding the profile (default 02 Jan 06)"`
+	ColDescription     int         `help:"Column number for description, overriding the profile"`
+	ColAmount          int         `help:"Column number for amount, +deposit, -withdrawal, overriding the profile"`
+	ColWithdrawal      int         `help:"Column number for payment, if applicable, overriding the profile"`
+	ColCurrency        int         `help:"Column number for the currency of the amount, if applicable, overriding the profile"`
+	ColForeignAmount   int         `help:"Column number for the amount in the original currency, if applicable, overriding the profile"`
+	ColForeignCurrency int         `help:"Column number for the original currency, if applicable, overriding the profile"`
+	ColBalance         int         `help:"Column number for the balance after each row, if applicable, overriding the profile"`
+	ApproxTransfer     string      `help:"String to find in description to approximately match transfers by month"`
+	Window             int         `help:"Days either side of the date to search for candidates"`
+	Tolerance          money.Money `help:"Amount either side of the amount to search for candidates"`
+	AskSplits          bool        `help:"Ask how to split each new transaction not split by a mapping rule"`
+	AutoAccept         float64     `help:"Accept the best candidate without asking if it scores at least this, from 0 to 1, and better than the rest; 0 always asks, unless a search widened by --window or --tolerance finds only one"`
+
+	NonInteractive bool   `help:"Never prompt, resolving rows with --on-none and --on-multiple instead"`
+	OnNone         string `help:"Non-interactive policy when no transaction is found: skip, create (with mapped account) or fail" enum:"skip,create,fail" default:"skip"`
+	OnMultiple     string `help:"Non-interactive policy when transactions are found but none accepted: skip, best, create (with mapped account) or fail" enum:"skip,best,create,fail" default:"skip"`
+	Unresolved     string `help:"Write rows left unresolved to this CSV file, with the reason appended" type:"path"`
+	Journal        string `help:"Directory of progress journals, so rerunning with the same file skips rows already matched or created" type:"path" default:"${journal}"`
+	Mapping        string `help:"JSON file of rules mapping descriptions to opposing accounts for new transactions" type:"existingfile"`
+
+	Reconcile           string `help:"Once every row is matched and the statement's closing balance agrees with Firefly's, mark the matched transactions reconciled: ask, yes or no" enum:"ask,yes,no" default:"ask"`
+	ReconcileDifference bool   `help:"Reconcile even if the closing balances differ, creating a reconciliation transaction for the difference"`
+
+	mapping     Mapping
+	profile     Profile
+	dateFormats []string
 }
 
-type accountMapping map[string]int
-
-var mapping = accountMapping{}
+// line is a row of the statement being matched.
+type line struct {
+	row                            int
+	rawDate, description           string
+	amount, foreign                money.Money
+	payment                        bool
+	date, processDate, paymentDate time.Time
+	// balance is the balance after the row, if hasBalance.
+	balance    money.Money
+	hasBalance bool
+}
 
-func (m accountMapping) match(s string) int {
-	for k, v := range mapping {
-		if strings.Contains(s, k) {
-			return v
-		}
+func (l line) title() string {
+	if !l.foreign.IsZero() {
+		return fmt.Sprintf("%d %s %q %v %s (%s %s)", l.row, l.rawDate, l.description, l.payment, l.amount, l.foreign, l.foreign.Currency)
 	}
-	return 0
+	return fmt.Sprintf("%d %s %q %v %s", l.row, l.rawDate, l.description, l.payment, l.amount)
 }
 
 func (m Match) Run(ctx context.Context, a API) error {
-	c := csv.NewReader(bytes.NewReader(m.File))
+	var err error
+	if m.mapping, err = LoadMapping(m.Mapping); err != nil {
+		return err
+	}
+	var unresolved *csv.Writer
+	if m.Unresolved != "" {
+		f, err := os.Create(m.Unresolved)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		unresolved = csv.NewWriter(f)
+		defer unresolved.Flush()
+	}
+
+	j, err := openJournal(m.Journal, m.File)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	if u, ok := a.Transport.(*UndoLog); ok && j != nil {
+		j.undo = u
+	}
+
+	if m.Profile != "" {
+		if m.profile, err = LoadProfile(m.Profile); err != nil {
+			return err
+		}
+	}
+	input, err := m.profile.decode(m.File)
+	if err != nil {
+		return err
+	}
+	c := csv.NewReader(bytes.NewReader(input))
 	c.ReuseRecord = true
-	var row int
+	if m.profile.Delimiter != "" {
+		c.Comma, _ = utf8.DecodeRuneInString(m.profile.Delimiter)
+	}
+	row := m.profile.SkipRows
+	var header []string
+	if m.profile.Header {
+		row++
+		if header, err = c.Read(); err != nil {
+			return fmt.Errorf("reading header: %w", err)
+		}
+	}
+	if err := m.layout(header); err != nil {
+		return err
+	}
+
+	var (
+		r  resolver = policy{onNone: m.OnNone, onMultiple: m.OnMultiple}
+		rv *review
+	)
+	if !m.NonInteractive {
+		rows := m.profile.SkipRows + bytes.Count(input, []byte{'\n'})
+		if len(input) > 0 && input[len(input)-1] != '\n' {
+			rows++
+		}
+		rv = newReview(rows, &accounts{ctx: ctx, a: a}, tea.WithContext(ctx))
+		r = rv
+		defer rv.Close()
+		// log below the review rather than over it
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(slog.New(slog.NewTextHandler(rv, nil)))
+	}
+	var p period
 	for record, err := c.Read(); err != io.EOF; record, err = c.Read() {
 		row++
 		if row < m.Start {
@@ -67,367 +162,631 @@ func (m Match) Run(ctx context.Context, a API) error {
 			continue
 		}
 
-		date, err := time.Parse(m.DateFormat, record[m.ColDate-1])
+		li, err := m.parse(row, record)
 		if err != nil {
-			l.Warn("invalid date", slog.String("err", err.Error()), slog.String("record", record[m.ColDate-1]))
+			l.Warn("invalid row", slog.String("err", err.Error()), slog.String("record", strings.Join(record, ",")))
+			p.open++
 			continue
 		}
-		// process date → payment date
-		processDate, paymentDate := date, date
-		if _, r, ok := strings.Cut(record[m.ColDescription-1], " ON "); ok {
-			s := strings.SplitAfterN(r, " ", 3)
-			if len(s) == 3 {
-				if override, err := time.Parse("02 Jan 2006", s[0]+" "+s[1]+" "+strconv.Itoa(date.Year())); err == nil {
-					date, processDate = override, override
-				}
+		if e, ok := j.done(row); ok {
+			l.Info("already processed", slog.String("outcome", e.Outcome), slog.Int("id", e.ID))
+			if rv != nil {
+				rv.finish(row, e.Outcome)
 			}
+			p.add(li, e)
+			continue
 		}
-
-		var (
-			amount  string
-			payment bool
-		)
-		if m.ColWithdrawal > 0 {
-			amount = record[m.ColWithdrawal-1]
-			if amount != "" {
-				payment = true
-			} else {
-				amount = record[m.ColAmount-1]
-			}
-		} else {
-			amount = record[m.ColAmount-1]
-			if strings.HasPrefix(amount, "-") {
-				amount = strings.TrimPrefix(amount, "-")
-				payment = true
+		if rv != nil {
+			if err := rv.start(li); err != nil {
+				return err
 			}
 		}
-
-		formattedDate := date.Format("2006-01-02")
-		if len(m.ApproxTransfer) > 0 && strings.HasPrefix(record[m.ColDescription-1], m.ApproxTransfer) {
-			formattedDate = formattedDate[0:8] + `xx type:"Transfer"`
+		e, err := m.match(ctx, a, r, li)
+		var u *unresolvedError
+		if errors.As(err, &u) {
+			l.Warn("unresolved", slog.String("title", li.title()), slog.String("reason", err.Error()))
+			if unresolved != nil {
+				if err := unresolved.Write(append(slices.Clone(record), err.Error())); err != nil {
+					return err
+				}
+			}
+			e = entry{Outcome: outcomeSkipped, Reason: err.Error()}
+		} else if err != nil {
+			return err
 		}
-		var res []transactions
-		q := make(url.Values, 1)
-		q.Add("query", fmt.Sprintf("account_id:%d date_on:%s amount:%s -tag_is:%s", m.AccountID, formattedDate, amount, m.Tag))
-		if err := Do(ctx, a, http.MethodGet, "search/transactions", q, &res, nil); err != nil {
+		e.Row = row
+		if err := j.record(e); err != nil {
 			return err
 		}
+		if rv != nil {
+			rv.finish(row, e.Outcome)
+		}
+		p.add(li, e)
+	}
 
-		if len(res) == 0 && !date.Equal(paymentDate) {
-			l.Info("no transactions found with process date, retrying with payment date")
-			q := make(url.Values, 1)
-			q.Add("query", fmt.Sprintf("account_id:%d date_on:%s amount:%s -tag_is:%s", m.AccountID, paymentDate.Format("2006-01-02"), amount, m.Tag))
-			if err := Do(ctx, a, http.MethodGet, "search/transactions", q, &res, nil); err != nil {
-				return err
+	summary, err := m.close(ctx, a, r, p)
+	if rv != nil {
+		rv.Close()
+	}
+	if summary != "" {
+		fmt.Println(summary)
+	}
+	return err
+}
+
+// parse reads a statement row, using the date given in the description as
+// the process date if there is one.
+func (m Match) parse(row int, record []string) (line, error) {
+	l := line{
+		row:         row,
+		rawDate:     record[m.ColDate-1],
+		description: record[m.ColDescription-1],
+	}
+	var err error
+	for _, format := range m.dateFormats {
+		if l.date, err = time.Parse(format, l.rawDate); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return l, err
+	}
+	// process date → payment date
+	l.processDate, l.paymentDate = l.date, l.date
+	if _, r, ok := strings.Cut(l.description, " ON "); ok {
+		s := strings.SplitAfterN(r, " ", 3)
+		if len(s) == 3 {
+			if override, err := time.Parse("02 Jan 2006", s[0]+" "+s[1]+" "+strconv.Itoa(l.date.Year())); err == nil {
+				l.date, l.processDate = override, override
 			}
 		}
+	}
 
-		title := fmt.Sprintf("%d %s %q %v %s", row, record[m.ColDate-1], record[m.ColDescription-1], payment, amount)
-		l = l.With("title", title)
+	var currency, foreignCurrency string
+	if m.ColCurrency > 0 {
+		currency = strings.TrimSpace(record[m.ColCurrency-1])
+	}
+	if m.ColForeignCurrency > 0 {
+		foreignCurrency = strings.TrimSpace(record[m.ColForeignCurrency-1])
+	}
+	if m.ColWithdrawal > 0 && record[m.ColWithdrawal-1] != "" {
+		l.payment = true
+		l.amount, err = money.Parse(record[m.ColWithdrawal-1], currency)
+	} else {
+		l.amount, err = money.Parse(record[m.ColAmount-1], currency)
+		l.payment = m.ColWithdrawal == 0 && (l.amount.Sign() < 0) != (m.profile.Sign == "positive")
+	}
+	if err != nil {
+		return l, err
+	}
+	l.amount = l.amount.Abs()
+	// a foreign amount in the statement's own currency is not foreign
+	if m.ColForeignAmount > 0 && record[m.ColForeignAmount-1] != "" && foreignCurrency != "" && !strings.EqualFold(foreignCurrency, currency) {
+		if l.foreign, err = money.Parse(record[m.ColForeignAmount-1], foreignCurrency); err != nil {
+			return l, err
+		}
+		l.foreign = l.foreign.Abs()
+	}
+	if m.ColBalance > 0 && strings.TrimSpace(record[m.ColBalance-1]) != "" {
+		if l.balance, err = money.Parse(record[m.ColBalance-1], currency); err != nil {
+			return l, err
+		}
+		l.hasBalance = true
+	}
+	return l, nil
+}
 
-		var selection transaction
-		switch len(res) {
-		case 0:
-			l.Info("no transactions found with process date (or payment date if different), asking for ID to match")
-			var id int
-			mappedAccountID := mapping.match(record[m.ColDescription-1])
-			if id == 0 && mappedAccountID == 0 {
-				id, err = askID(title)
-				if err != nil {
-					return err
-				}
-			}
-			if id == 0 {
-				var f StringFloat
-				f.UnmarshalText([]byte(amount))
-				l.Info("require opposing account ID", slog.Float64("amount", float64(f)))
-				id = mappedAccountID
-				if id == 0 {
-					id, err = askID(title)
-					if err != nil {
-						return err
-					}
-				}
-				if id == 0 {
-					return errors.New("cancelling")
-				}
-				source, destination, t := id, m.AccountID, "deposit"
-				if payment {
-					source, destination, t = destination, source, "withdrawal"
-				}
-				if slices.Contains(m.AssetIDs, source) && slices.Contains(m.AssetIDs, destination) {
-					t = "transfer"
-				}
-				if err := upsert(ctx, a, http.MethodPost, transaction{
-					Date:          date,
-					ProcessDate:   processDate,
-					PaymentDate:   paymentDate,
-					Type:          t,
-					Description:   record[m.ColDescription-1],
-					SourceID:      StringInt(source),
-					DestinationID: StringInt(destination),
-					Amount:        f,
-					Tags:          []string{m.Tag},
-				}); err != nil {
-					return err
-				}
-				continue
-			}
-			var re transactions
-			if err := Do(ctx, a, http.MethodGet, "transactions/"+strconv.Itoa(id), nil, &re, nil); err != nil {
-				return err
-			}
-			res = []transactions{re}
-			fallthrough
+// match finds the Firefly transaction for li, asking r to resolve it when
+// there is not exactly one, and tags it or creates a new one.
+func (m Match) match(ctx context.Context, a API, r resolver, li line) (entry, error) {
+	l := slog.With(slog.Int("row", li.row))
+	approx := len(m.ApproxTransfer) > 0 && strings.HasPrefix(li.description, m.ApproxTransfer)
+	res, err := m.search(ctx, a, li, li.date, approx)
+	if err != nil {
+		return entry{}, err
+	}
 
-		case 1:
-			l.Info("exact match")
-			if len(res[0].Attributes.Transactions) != 1 {
-				l.Error("target contains split, skipping", slog.Int("target", int(res[0].ID)))
-				continue
-			}
-			res[0].Attributes.Transactions[0].topID = int(res[0].ID)
-			selection = res[0].Attributes.Transactions[0]
+	if len(res) == 0 && !li.date.Equal(li.paymentDate) {
+		l.Info("no transactions found with process date, retrying with payment date")
+		if res, err = m.search(ctx, a, li, li.paymentDate, false); err != nil {
+			return entry{}, err
+		}
+	}
 
-		default:
-			options := slices.Collect(func(yield func(transaction) bool) {
-				for i := range res {
-					for j := range res[i].Attributes.Transactions {
-						res[i].Attributes.Transactions[j].topID = int(res[i].ID)
-						if !yield(res[i].Attributes.Transactions[j]) {
-							return
-						}
-					}
-				}
-			})
-			i, err := pick(options, title)
+	title := li.title()
+	l = l.With("title", title)
+
+	rule, mapped := m.mapping.Match(li.description)
+	var (
+		selection   candidate
+		description string
+	)
+	switch {
+	case len(res) == 0:
+		l.Info("no transactions found with process date (or payment date if different), asking for ID to match")
+		var id int
+		if mapped < 0 {
+			id, err = r.none(title)
 			if err != nil {
-				return err
+				return entry{}, err
 			}
-			if i < 0 {
-				var f StringFloat
-				f.UnmarshalText([]byte(amount))
-				l.Info("require opposing account ID", slog.Float64("amount", float64(f)))
-				id, err := askID(title)
-				if err != nil {
-					return err
-				}
-				if id == 0 {
-					return errors.New("cancelling")
-				}
-				source, destination, t := id, m.AccountID, "deposit"
-				if payment {
-					source, destination, t = destination, source, "withdrawal"
-				}
-				if err := upsert(ctx, a, http.MethodPost, transaction{
-					Date:          date,
-					ProcessDate:   processDate,
-					PaymentDate:   paymentDate,
-					Type:          t,
-					Description:   record[m.ColDescription-1],
-					SourceID:      StringInt(source),
-					DestinationID: StringInt(destination),
-					Amount:        f,
-					Tags:          []string{m.Tag},
-				}); err != nil {
-					return err
-				}
-				continue
-			}
-			selection = options[i]
+		} else {
+			l.Info("mapped", slog.String("rule", rule.String()))
+		}
+		if id == 0 {
+			return m.create(ctx, a, r, li, rule)
+		}
+		re, err := a.Transaction(ctx, id)
+		if err != nil {
+			return entry{}, err
 		}
-		l.Info("made selection", slog.String("selection", selection.String()))
+		res = []Object[TransactionGroup]{re}
+		fallthrough
 
-		switch selection.Description {
-		case "(empty description)":
-			l.Info("description was empty")
-			selection.Description = record[m.ColDescription-1]
-		case record[m.ColDescription-1]:
-			l.Info("description already matches")
-		default:
-			if !m.KeepDescription {
-				desc, err := askText(title+" — "+selection.Description, record[m.ColDescription-1])
-				if err != nil {
-					return err
-				}
-				selection.Description = desc
+	case len(res) == 1 && m.exact() && (len(res[0].Attributes.Transactions) == 1 || total(res[0].Attributes.Transactions).Units == li.amount.Units):
+		l.Info("exact match")
+		if len(res[0].Attributes.Transactions) == 0 {
+			return entry{}, fmt.Errorf("transaction %d has no splits", res[0].ID)
+		}
+		selection = newCandidate(res[0])
+
+	default:
+		var options []candidate
+		for _, o := range res {
+			options = append(options, newCandidate(o))
+		}
+		window := time.Duration(m.Window) * 24 * time.Hour
+		for i := range options {
+			options[i].score = score(li, options[i], window, m.tolerance(li.amount.Currency), rule)
+		}
+		slices.SortStableFunc(options, func(a, b candidate) int {
+			return cmp.Compare(b.score, a.score)
+		})
+		var ch choice
+		// a lone candidate of a search widened by --window or --tolerance
+		// is accepted as an exact match would be, unless it scores below
+		// --auto-accept
+		lone := len(options) == 1 && !m.exact() && (m.AutoAccept == 0 || options[0].score >= m.AutoAccept)
+		if lone {
+			l.Info("only candidate", slog.Float64("score", options[0].score))
+		} else if m.AutoAccept == 0 || options[0].score < m.AutoAccept || len(options) > 1 && options[1].score == options[0].score {
+			if ch, err = r.pick(title, li, options); err != nil {
+				return entry{}, err
 			}
+		} else {
+			l.Info("auto-accepted", slog.Float64("score", options[0].score))
+		}
+		switch {
+		case ch.id != 0:
+			o, err := a.Transaction(ctx, ch.id)
+			if err != nil {
+				return entry{}, err
+			}
+			if len(o.Attributes.Transactions) == 0 {
+				return entry{}, fmt.Errorf("transaction %d has no splits", o.ID)
+			}
+			selection = newCandidate(o)
+		case ch.index < 0:
+			return m.create(ctx, a, r, li, rule)
+		default:
+			selection = options[ch.index]
+		}
+		description = ch.description
+	}
+	l.Info("made selection", slog.String("selection", selection.String()))
+
+	// the description of split transactions is their title
+	g := TransactionGroup{Transactions: []Transaction{selection.Transaction}}
+	desc := &g.Transactions[0].Description
+	if len(selection.splits) > 1 {
+		g = TransactionGroup{GroupTitle: selection.title, Transactions: selection.splits}
+		desc = &g.GroupTitle
+	}
+	switch {
+	case description != "":
+		l.Info("description edited")
+		*desc = description
+	case *desc == "" || *desc == "(empty description)":
+		l.Info("description was empty")
+		*desc = li.description
+	case *desc == li.description:
+		l.Info("description already matches")
+	case !m.KeepDescription:
+		d, err := r.text(title+" — "+*desc, li.description)
+		if err != nil {
+			return entry{}, err
 		}
+		*desc = d
+	}
 
-		selection.Tags = append(selection.Tags, m.Tag)
-		selection.PaymentDate = paymentDate
-		selection.ProcessDate = processDate
+	for i := range g.Transactions {
+		t := &g.Transactions[i]
+		t.Tags = append(t.Tags, m.Tag)
+		t.PaymentDate = li.paymentDate
+		t.ProcessDate = li.processDate
+	}
 
-		if err := upsert(ctx, a, http.MethodPut, selection); err != nil {
+	id, err := upsert(ctx, a, r, selection.groupID, g)
+	return entry{Outcome: outcomeMatched, ID: id}, err
+}
+
+// layout fills in the columns and date formats not given by flags from the
+// profile, finding columns named by the profile in header, and checks the
+// columns are in header if there is one.
+func (m *Match) layout(header []string) error {
+	var (
+		names []string
+		named []*int
+	)
+	for _, c := range []struct {
+		col  *int
+		from Column
+	}{
+		{&m.ColDate, m.profile.Date},
+		{&m.ColDescription, m.profile.Description},
+		{&m.ColAmount, m.profile.Amount},
+		{&m.ColWithdrawal, m.profile.Withdrawal},
+		{&m.ColCurrency, m.profile.Currency},
+		{&m.ColForeignAmount, m.profile.ForeignAmount},
+		{&m.ColForeignCurrency, m.profile.ForeignCurrency},
+		{&m.ColBalance, m.profile.Balance},
+	} {
+		switch {
+		case *c.col != 0:
+		case c.from.Name != "":
+			names, named = append(names, c.from.Name), append(named, c.col)
+		default:
+			*c.col = c.from.Index
+		}
+	}
+	if names != nil {
+		if header == nil {
+			return fmt.Errorf("columns %s need a profile with a header", strings.Join(names, ", "))
+		}
+		indexes, err := csvhead.New(header).Indexes(names...)
+		if err != nil {
 			return err
 		}
+		for i, col := range named {
+			*col = indexes[i] + 1
+		}
+	}
+	if header != nil {
+		if n := max(m.ColDate, m.ColDescription, m.ColAmount, m.ColWithdrawal, m.ColCurrency, m.ColForeignAmount, m.ColForeignCurrency, m.ColBalance); n > len(header) {
+			return fmt.Errorf("column %d not in header of %d columns: %s", n, len(header), strings.Join(header, ", "))
+		}
+	}
+	if m.ColDate == 0 || m.ColDescription == 0 || m.ColAmount == 0 {
+		return errors.New("date, description and amount columns must be given by --profile or --col-date, --col-description and --col-amount")
+	}
+	m.dateFormats = m.profile.DateFormats
+	if m.DateFormat != "" {
+		m.dateFormats = []string{m.DateFormat}
+	}
+	if len(m.dateFormats) == 0 {
+		m.dateFormats = []string{"02 Jan 06"}
 	}
-
 	return nil
 }
 
-func pick(options []transaction, title string) (int, error) {
-	l := list.New(make([]list.Item, len(options)), itemDelegate{options}, 73, min(len(options)+6, 10))
-	l.Title = title
-	l.SetShowStatusBar(false)
-	l.SetFilteringEnabled(false)
-	m := listModel{Model: l}
-	_, err := tea.NewProgram(&m).Run()
-	return m.int, err
-}
+func (m Match) exact() bool { return m.Window == 0 && m.Tolerance.IsZero() }
 
-func askID(title string) (int, error) {
-	fmt.Println(title)
-	input := textinput.New()
-	input.Validate = func(s string) error {
-		_, err := strconv.Atoi(s)
-		return err
+// search returns the untagged transaction groups of the account on date for
+// li's amount or, if it has one, its foreign amount. If approx is set, it
+// searches for transfers of the amount in the month of date instead.
+func (m Match) search(ctx context.Context, a API, li line, date time.Time, approx bool) ([]Object[TransactionGroup], error) {
+	terms := []string{m.terms(date, li.amount, false)}
+	if approx {
+		terms[0] = fmt.Sprintf(`date_on:%s type:"Transfer" amount:%s`, date.Format("2006-01-xx"), li.amount)
 	}
-	input.Focus()
-	input.Placeholder = "ID"
-	input.SetWidth(73)
-	m := textModel{Model: input}
-	_, err := tea.NewProgram(&m).Run()
-	if err != nil {
-		if m.Value() == "" {
-			return 0, nil
+	if !li.foreign.IsZero() {
+		terms = append(terms, m.terms(date, li.foreign, true))
+	}
+	var res []Object[TransactionGroup]
+	for _, t := range terms {
+		q := fmt.Sprintf("account_id:%d %s -tag_is:%s", m.AccountID, t, m.Tag)
+		for g, err := range a.SearchTransactions(ctx, q) {
+			if err != nil {
+				return nil, err
+			}
+			// a group without splits is no candidate
+			if len(g.Attributes.Transactions) > 0 && !slices.ContainsFunc(res, func(r Object[TransactionGroup]) bool { return r.ID == g.ID }) {
+				res = append(res, g)
+			}
 		}
-		return 0, err
 	}
-	return strconv.Atoi(m.Value())
-}
+	if len(res) > 0 || approx {
+		return res, nil
+	}
 
-func askText(title, value string) (string, error) {
-	fmt.Println(title)
-	input := textinput.New()
-	input.Focus()
-	input.Placeholder = ""
-	input.SetValue(value)
-	input.SetWidth(73)
-	m := textModel{Model: input}
-	_, err := tea.NewProgram(&m).Run()
-	if err != nil {
-		if m.Value() == "" {
-			return "", nil
+	// splits are found by their own amounts, so look for split
+	// transactions on the date whose total is the amount instead
+	q := fmt.Sprintf("account_id:%d %s -tag_is:%s", m.AccountID, m.dates(date), m.Tag)
+	for g, err := range a.SearchTransactions(ctx, q) {
+		if err != nil {
+			return nil, err
+		}
+		splits := g.Attributes.Transactions
+		if d := total(splits).Units - li.amount.Units; len(splits) > 1 && max(d, -d) <= m.tolerance(li.amount.Currency).Units {
+			res = append(res, g)
 		}
-		return "", err
 	}
-	return m.Value(), nil
+	return res, nil
 }
 
-func upsert(ctx context.Context, a API, method string, t transaction) error {
-	json.MarshalWrite(os.Stdout, t)
-	io.WriteString(os.Stdout, "\n")
-	body, err := json.Marshal(transactionUpdate{
-		Transactions: []transaction{t},
-	})
-	if err != nil {
-		return err
+// terms returns the search terms for transactions on date for amount, or
+// within the window and tolerance around them, matching the foreign amount
+// of transactions instead if foreign is set.
+func (m Match) terms(date time.Time, amount money.Money, foreign bool) string {
+	field := "amount"
+	if foreign {
+		field = "foreign_amount"
 	}
-	var out any
-	path := "transactions"
-	if method == http.MethodPut {
-		path += "/" + strconv.Itoa(int(t.topID))
+	tolerance := m.tolerance(amount.Currency)
+	if tolerance.IsZero() {
+		if foreign {
+			return fmt.Sprintf("%s foreign_amount_is:%s", m.dates(date), amount)
+		}
+		return fmt.Sprintf("%s amount:%s", m.dates(date), amount)
 	}
-	if err := Do(ctx, a, method, path, nil, &out, bytes.NewReader(body)); err != nil {
-		return err
+	more := amount.Sub(tolerance)
+	if more.Sign() < 0 {
+		more.Units = 0
 	}
-	json.MarshalWrite(os.Stdout, out)
-	io.WriteString(os.Stdout, "\n")
-	return nil
+	return fmt.Sprintf("%s %[2]s_more:%[3]s %[2]s_less:%[4]s", m.dates(date), field, more, amount.Add(tolerance))
 }
 
-type listModel struct {
-	list.Model
-	int
+// tolerance returns m.Tolerance in currency, truncated to its decimal
+// places, so 0.10 is no yen rather than 10.
+func (m Match) tolerance(currency string) money.Money {
+	units := m.Tolerance.Units
+	for d := money.Decimals(currency) - money.Decimals(m.Tolerance.Currency); d != 0; {
+		if d > 0 {
+			units, d = units*10, d-1
+		} else {
+			units, d = units/10, d+1
+		}
+	}
+	return money.New(units, currency)
 }
 
-func (m listModel) Init() tea.Cmd { return nil }
-func (m *listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	if cmd := keypress(msg); cmd != nil {
-		// workaround global index being 0 after exit
-		m.int = m.Model.GlobalIndex()
-		return m, cmd
+// dates returns the search terms for transactions on date, or within the
+// window around it.
+func (m Match) dates(date time.Time) string {
+	if m.Window == 0 {
+		return "date_on:" + date.Format(time.DateOnly)
 	}
-	var cmd tea.Cmd
-	m.Model, cmd = m.Model.Update(msg)
-	return m, cmd
+	return fmt.Sprintf("date_after:%s date_before:%s",
+		date.AddDate(0, 0, -m.Window).Format(time.DateOnly), date.AddDate(0, 0, m.Window).Format(time.DateOnly))
 }
 
-type textModel struct{ textinput.Model }
+// create stores li as a new transaction against the opposing account of
+// rule, asking r for the account if rule has none. The transaction is split
+// as the rule says or, if set to ask, as r says.
+func (m Match) create(ctx context.Context, a API, r resolver, li line, rule MappingRule) (entry, error) {
+	id := rule.AccountID
+	if id == 0 {
+		slog.Info("require opposing account", slog.Int("row", li.row), slog.String("amount", li.amount.String()))
+		accountType := "revenue"
+		if li.payment {
+			accountType = "expense"
+		}
+		var err error
+		if id, err = r.account(li.title(), accountType); err != nil {
+			return entry{}, err
+		}
+	}
+	if id == 0 {
+		return entry{}, errors.New("cancelling")
+	}
+	splits := rule.Splits
+	if splits == nil && m.AskSplits {
+		s, err := r.text(li.title()+" — splits, such as 20.00 Groceries; Household", "")
+		if err != nil {
+			return entry{}, err
+		}
+		if splits, err = parseSplits(s); err != nil {
+			return entry{}, err
+		}
+	}
+	g, err := newTransaction(li, m.AccountID, id, m.AssetIDs, rule, splits, m.Tag)
+	if err != nil {
+		return entry{}, err
+	}
+	id, err = upsert(ctx, a, r, 0, g)
+	return entry{Outcome: outcomeCreated, ID: id}, err
+}
 
-func (m textModel) Init() tea.Cmd { return textinput.Blink }
-func (m *textModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	if cmd := keypress(msg); cmd != nil {
-		return m, cmd
+// newTransaction returns a new transaction for li between account and
+// opposing, a transfer if both are in assets, categorised by rule and
+// divided by splits. An opposing account of zero is named after the
+// description, which Firefly creates if it does not exist.
+func newTransaction(li line, account, opposing int, assets []int, rule MappingRule, splits []MappingSplit, tag string) (TransactionGroup, error) {
+	t := Transaction{
+		Date:                li.date,
+		ProcessDate:         li.processDate,
+		PaymentDate:         li.paymentDate,
+		Type:                "deposit",
+		Description:         li.description,
+		SourceID:            StringInt(opposing),
+		DestinationID:       StringInt(account),
+		Amount:              li.amount,
+		CurrencyCode:        li.amount.Currency,
+		ForeignAmount:       li.foreign,
+		ForeignCurrencyCode: li.foreign.Currency,
+		Category:            rule.Category,
+		Budget:              rule.Budget,
+		Tags:                append([]string{tag}, rule.Tags...),
+	}
+	if opposing == 0 {
+		t.Source = li.description
+	}
+	if li.payment {
+		t.Type = "withdrawal"
+		t.SourceID, t.DestinationID = t.DestinationID, t.SourceID
+		t.Source, t.Destination = "", t.Source
+	}
+	if slices.Contains(assets, account) && slices.Contains(assets, opposing) {
+		t.Type = "transfer"
 	}
-	var cmd tea.Cmd
-	m.Model, cmd = m.Model.Update(msg)
-	return m, cmd
+	return split(t, splits, li.payment)
 }
 
-func keypress(msg tea.Msg) tea.Cmd {
-	if key, ok := msg.(tea.KeyMsg); ok {
-		switch keypress := key.String(); keypress {
-		case "enter":
-			return tea.Quit
-		case "q", "ctrl+c":
-			return tea.Interrupt
+// split divides t into a split transaction with the given splits, each
+// overriding its details and, for payments, destination or else source.
+// Foreign amounts cannot be divided, so splits have none.
+func split(t Transaction, splits []MappingSplit, payment bool) (TransactionGroup, error) {
+	if len(splits) == 0 {
+		return TransactionGroup{Transactions: []Transaction{t}}, nil
+	}
+	amounts, err := amounts(t.Amount, splits)
+	if err != nil {
+		return TransactionGroup{}, err
+	}
+	g := TransactionGroup{GroupTitle: t.Description}
+	for i, s := range splits {
+		st := t
+		st.Amount = amounts[i]
+		st.ForeignAmount, st.ForeignCurrencyCode = money.Money{}, ""
+		st.Description = cmp.Or(s.Description, t.Description)
+		st.Category = cmp.Or(s.Category, t.Category)
+		st.Budget = cmp.Or(s.Budget, t.Budget)
+		st.Tags = append(slices.Clip(t.Tags), s.Tags...)
+		switch {
+		case s.AccountID == 0:
+		case payment:
+			st.DestinationID = StringInt(s.AccountID)
+		default:
+			st.SourceID = StringInt(s.AccountID)
 		}
+		g.Transactions = append(g.Transactions, st)
 	}
-	return nil
+	return g, nil
 }
 
-type transactionUpdate struct {
-	Transactions []transaction `json:"transactions"`
+// upsert creates g as a new transaction if groupID is zero, or otherwise
+// updates the group, returning the group ID. If Firefly rejects a field the
+// user can correct, they are prompted for a new value and the request is
+// retried.
+func upsert(ctx context.Context, a API, r resolver, groupID int, g TransactionGroup) (int, error) {
+	for {
+		if b, err := json.Marshal(g); err == nil {
+			slog.Debug("sending", slog.Int("group", groupID), slog.String("body", string(b)))
+		}
+		var (
+			out Object[TransactionGroup]
+			err error
+		)
+		if groupID == 0 {
+			out, err = a.CreateTransaction(ctx, g)
+		} else {
+			out, err = a.UpdateTransaction(ctx, groupID, g)
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && len(apiErr.Errors) > 0 {
+			for _, field := range apiErr.Fields() {
+				slog.Error("field rejected", slog.String("field", field), slog.String("err", strings.Join(apiErr.Errors[field], " ")))
+			}
+			var (
+				retry bool
+				perr  error
+			)
+			for i := range g.Transactions {
+				rejected := apiErr.Split(i)
+				if len(rejected) == 0 {
+					continue
+				}
+				if retry, perr = reprompt(r, &g.Transactions[i], rejected); !retry || perr != nil {
+					break
+				}
+			}
+			if perr != nil {
+				return 0, errors.Join(err, perr)
+			}
+			if retry {
+				continue
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		slog.Info("saved", slog.Int("group", int(out.ID)))
+		return int(out.ID), nil
+	}
 }
 
-type transaction struct {
-	ID            StringInt   `json:"transaction_journal_id,omitzero"`
-	Date          time.Time   `json:"date"`
-	ProcessDate   time.Time   `json:"process_date,omitzero"` // start
-	PaymentDate   time.Time   `json:"payment_date,omitzero"` // end
-	Type          string      `json:"type"`
-	Description   string      `json:"description"`
-	Source        string      `json:"source_name,omitzero"`
-	SourceID      StringInt   `json:"source_id,omitzero"`
-	Destination   string      `json:"destination_name,omitzero"`
-	DestinationID StringInt   `json:"destination_id,omitzero"`
-	Amount        StringFloat `json:"amount"`
-	Tags          []string    `json:"tags,omitzero"`
-
-	topID int
+// reprompt asks for new values of rejected fields of t, reporting whether
+// every rejected field was corrected so the request can be retried. A value
+// left unchanged is not a correction.
+func reprompt(r resolver, t *Transaction, rejected map[string][]string) (bool, error) {
+	if len(rejected) == 0 {
+		return false, nil
+	}
+	for _, field := range slices.Sorted(maps.Keys(rejected)) {
+		title := fmt.Sprintf("%s rejected: %s", field, strings.Join(rejected[field], " "))
+		switch field {
+		case "source_id", "source_name":
+			id, err := r.account(title, "revenue")
+			if err != nil || id == 0 || StringInt(id) == t.SourceID {
+				return false, err
+			}
+			t.SourceID, t.Source = StringInt(id), ""
+		case "destination_id", "destination_name":
+			id, err := r.account(title, "expense")
+			if err != nil || id == 0 || StringInt(id) == t.DestinationID {
+				return false, err
+			}
+			t.DestinationID, t.Destination = StringInt(id), ""
+		case "description":
+			desc, err := r.correct(title, t.Description)
+			if err != nil || desc == "" || desc == t.Description {
+				return false, err
+			}
+			t.Description = desc
+		default:
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
-func (t transaction) String() string {
-	return fmt.Sprintf("%d %s %q (%s → %s) %.2f", t.ID, t.Date.Format("02 Jan 2006"), t.Description, t.Source, t.Destination, t.Amount)
+// candidate is a transaction group found when matching. Split transactions
+// are described by their title and total amount, and keep their splits.
+type candidate struct {
+	groupID int
+	score   float64
+	title   string
+	splits  []Transaction
+	Transaction
 }
 
-type zeroItem struct{}
-
-// FilterValue implements [list.Item].
-func (t zeroItem) FilterValue() string { return "" }
-
-type transactions struct {
-	ID         StringInt         `json:"id"`
-	Attributes transactionUpdate `json:"attributes"`
+func newCandidate(o Object[TransactionGroup]) candidate {
+	splits := o.Attributes.Transactions
+	c := candidate{groupID: int(o.ID), splits: splits, Transaction: splits[0]}
+	if len(splits) > 1 {
+		c.title = o.Attributes.GroupTitle
+		c.Description = cmp.Or(c.title, c.Description)
+		c.Amount = total(splits)
+	}
+	return c
 }
 
-type simpleItem string
-
-var _ list.DefaultItem = (*simpleItem)(nil)
-
-func (s simpleItem) FilterValue() string { return string(s) }
-func (s simpleItem) Title() string       { return string(s) }
-func (s simpleItem) Description() string { return "" }
-
-type itemDelegate struct{ options []transaction }
+func (c candidate) String() string {
+	if len(c.splits) < 2 {
+		return c.Transaction.String()
+	}
+	parts := make([]string, len(c.splits))
+	for i, t := range c.splits {
+		parts[i] = fmt.Sprintf("%q %s", t.Description, t.Amount)
+	}
+	return fmt.Sprintf("%s [%s]", c.Transaction, strings.Join(parts, "; "))
+}
 
-func (d itemDelegate) Height() int                             { return 1 }
-func (d itemDelegate) Spacing() int                            { return 0 }
-func (d itemDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
-func (d itemDelegate) Render(w io.Writer, m list.Model, i int, _ list.Item) {
-	dd := list.NewDefaultDelegate()
-	dd.ShowDescription = false
-	dd.Render(w, m, i, simpleItem(d.options[i].String()))
+// total returns the sum of the amounts of splits.
+func total(splits []Transaction) money.Money {
+	var sum money.Money
+	for _, t := range splits {
+		sum = sum.Add(t.Amount.Abs())
+	}
+	return sum
 }
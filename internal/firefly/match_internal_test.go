@@ -0,0 +1,44 @@
+package firefly
+
+import (
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestTerms(t *testing.T) {
+	m := Match{Tolerance: money.MustParse("0.10", "")}
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		amount  money.Money
+		foreign bool
+		want    string
+	}{
+		{money.MustParse("3.00", "GBP"), false, "date_on:2024-01-01 amount_more:2.90 amount_less:3.10"},
+		{money.MustParse("1.500", "KWD"), true, "date_on:2024-01-01 foreign_amount_more:1.400 foreign_amount_less:1.600"},
+		// a tolerance finer than the currency's smallest unit is none
+		{money.MustParse("500", "JPY"), true, "date_on:2024-01-01 foreign_amount_is:500"},
+	} {
+		if got := m.terms(date, tt.amount, tt.foreign); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.amount, tt.amount.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestTolerance(t *testing.T) {
+	m := Match{Tolerance: money.MustParse("0.10", "")}
+	for currency, want := range map[string]int64{"": 10, "GBP": 10, "KWD": 100, "JPY": 0} {
+		if got := m.tolerance(currency); got.Units != want || got.Currency != currency {
+			t.Errorf("%q: got %d units %s, want %d", currency, got.Units, got.Currency, want)
+		}
+	}
+
+	// 0.020 dinar apart is within a tolerance of 0.100 dinar
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	li := line{date: date, paymentDate: date, description: "TESCO", amount: money.MustParse("10.000", "KWD")}
+	c := candidate{Transaction: Transaction{Date: date, Description: "TESCO", Amount: money.MustParse("10.020", "KWD")}}
+	if s := score(li, c, 0, m.tolerance("KWD"), MappingRule{}); s < 0.85 {
+		t.Errorf("got score %.2f, want at least 0.85", s)
+	}
+}
@@ -0,0 +1,368 @@
+package firefly_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestMatchLedger(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	ids := seed(s,
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("12.34", ""), Description: "(empty description)"},
+		firefly.Transaction{Type: "deposit", Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("100.00", ""), Description: "Salary", SourceID: 6, DestinationID: 1, Tags: []string{"gdpr"}},
+	)
+
+	m := newMatch(t, "01 Jan 24,TESCO STORES ON 31 DEC BCC,-12.34\n")
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ := s.Transaction(ids[0])
+	got := g.Transactions[0]
+	if got.Description != "TESCO STORES ON 31 DEC BCC" {
+		t.Errorf("got description %q", got.Description)
+	}
+	if !slices.Contains(got.Tags, "gdpr") {
+		t.Errorf("got tags %v, want gdpr", got.Tags)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !got.PaymentDate.Equal(want) {
+		t.Errorf("got payment date %v, want %v", got.PaymentDate, want)
+	}
+	if g, _ := s.Transaction(ids[1]); len(g.Transactions[0].Tags) != 1 {
+		t.Errorf("unmatched transaction was modified: %v", g.Transactions[0])
+	}
+}
+
+func TestMatchDryRun(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	id := seed(s, firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("12.34", ""), Description: "Tesco"})[0]
+
+	a := s.API()
+	d := &firefly.DryRun{}
+	a.Transport = d
+	m := newMatch(t, "01 Jan 24,TESCO STORES,-12.34\n")
+	if err := m.Run(t.Context(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _ := s.Transaction(id); len(g.Transactions[0].Tags) != 0 {
+		t.Errorf("dry run modified transaction: %v", g.Transactions[0].Tags)
+	}
+	var b strings.Builder
+	d.Summary(&b)
+	for _, want := range []string{
+		"PUT /api/v1/transactions/" + strconv.Itoa(id),
+		`transactions.0.tags: (unset) → ["gdpr"]`,
+		`transactions.0.payment_date: (unset) → "2024-01-01T00:00:00Z"`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("summary missing %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "transactions.0.amount") {
+		t.Errorf("summary includes unchanged amount:\n%s", b.String())
+	}
+}
+
+func TestMatchNonInteractive(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	ids := seed(s,
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.00", ""), Description: "Coffee"},
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.00", ""), Description: "Coffee shop"},
+	)
+
+	for _, tt := range []struct {
+		onMultiple string
+		tagged     int
+		unresolved string
+	}{
+		{"skip", 0, "01 Jan 24,COFFEE SHOP,-3.00,2 transactions found\n02 Jan 24,BAKERY,-4.00,no transaction found\n"},
+		{"best", ids[1], "02 Jan 24,BAKERY,-4.00,no transaction found\n"},
+	} {
+		t.Run(tt.onMultiple, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "unresolved.csv")
+			m := newMatch(t, "01 Jan 24,COFFEE SHOP,-3.00\n02 Jan 24,BAKERY,-4.00\n")
+			m.Tag = tt.onMultiple
+			m.NonInteractive, m.OnNone, m.OnMultiple = true, "skip", tt.onMultiple
+			m.Unresolved = path
+			if err := m.Run(t.Context(), s.API()); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range ids {
+				g, _ := s.Transaction(id)
+				if tagged := slices.Contains(g.Transactions[0].Tags, tt.onMultiple); tagged != (id == tt.tagged) {
+					t.Errorf("transaction %d tagged %v", id, tagged)
+				}
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.unresolved {
+				t.Errorf("got unresolved\n%s\nwant\n%s", b, tt.unresolved)
+			}
+		})
+	}
+}
+
+func TestMatchMapping(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(path, []byte(`{"rules": [
+		{"regex": "^TESCO( STORES)?$", "account_id": 7, "category": "Groceries", "tags": ["food"]},
+		{"contains": "TESCO", "account_id": 8},
+		{"exact": "SALARY", "account_id": 9, "budget": "Income"}
+	]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping, err := firefly.LoadMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for desc, want := range map[string]int{"TESCO STORES": 7, "TESCO PETROL": 8, "SALARY": 9, "SALARY BONUS": 0} {
+		if r, _ := mapping.Match(desc); r.AccountID != want {
+			t.Errorf("%q mapped to account %d, want %d", desc, r.AccountID, want)
+		}
+	}
+
+	m := newMatch(t, "01 Mar 24,TESCO STORES,12.50\n")
+	m.NonInteractive, m.OnNone, m.OnMultiple = true, "skip", "skip"
+	m.Mapping = path
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	ids := s.Transactions()
+	if len(ids) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(ids))
+	}
+	g, _ := s.Transaction(ids[0])
+	tr := g.Transactions[0]
+	if tr.SourceID != 7 || tr.Category != "Groceries" || !slices.Equal(tr.Tags, []string{"gdpr", "food"}) {
+		t.Errorf("created %+v", tr)
+	}
+}
+
+func TestMatchScored(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	ids := seed(s,
+		firefly.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.10", ""), Description: "Coffee Shop"},
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.00", ""), Description: "Bakery", SourceID: 1, DestinationID: 6},
+	)
+	shop, bakery := ids[0], ids[1]
+
+	for _, tt := range []struct {
+		autoAccept float64
+		tagged     bool
+	}{
+		{0.9, false},
+		{0.7, true},
+	} {
+		m := newMatch(t, "01 Jan 24,COFFEE SHOP,-3.05\n")
+		m.Window, m.Tolerance, m.AutoAccept = 2, money.MustParse("0.10", ""), tt.autoAccept
+		m.NonInteractive, m.OnNone, m.OnMultiple = true, "skip", "skip"
+		if err := m.Run(t.Context(), s.API()); err != nil {
+			t.Fatal(err)
+		}
+		g, _ := s.Transaction(shop)
+		if tagged := slices.Contains(g.Transactions[0].Tags, "gdpr"); tagged != tt.tagged {
+			t.Errorf("auto-accept %v: shop tagged %v, want %v", tt.autoAccept, tagged, tt.tagged)
+		}
+		if g, _ := s.Transaction(bakery); len(g.Transactions[0].Tags) > 0 {
+			t.Errorf("auto-accept %v: bakery tagged", tt.autoAccept)
+		}
+	}
+}
+
+func TestMatchLoneCandidate(t *testing.T) {
+	for _, tt := range []struct {
+		autoAccept float64
+		tagged     bool
+	}{
+		// the only candidate is accepted with --auto-accept 0, as an exact
+		// match would be, but not if it scores below a higher --auto-accept
+		{0, true},
+		{0.99, false},
+	} {
+		s := fireflytest.NewServer()
+		id := seed(s, firefly.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.00", ""), Description: "Tesco"})[0]
+		m := newMatch(t, "01 Jan 24,TESCO STORES,-3.00\n")
+		m.Window, m.AutoAccept = 2, tt.autoAccept
+		m.NonInteractive, m.OnNone, m.OnMultiple = true, "skip", "skip"
+		if err := m.Run(t.Context(), s.API()); err != nil {
+			t.Fatal(err)
+		}
+		g, _ := s.Transaction(id)
+		if tagged := slices.Contains(g.Transactions[0].Tags, "gdpr"); tagged != tt.tagged {
+			t.Errorf("auto-accept %v: tagged %v, want %v", tt.autoAccept, tagged, tt.tagged)
+		}
+		s.Close()
+	}
+}
+
+func TestMatchProfile(t *testing.T) {
+	for _, name := range []string{"barclays", "reexport", "monzo"} {
+		if _, err := firefly.LoadProfile(name); err != nil {
+			t.Errorf("built-in profile %s: %v", name, err)
+		}
+	}
+
+	s := fireflytest.NewServer()
+	defer s.Close()
+	card := seed(s, firefly.Transaction{Date: time.Date(2024, 4, 5, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("2.50", ""), Description: "Café"})[0]
+	path := filepath.Join(t.TempDir(), "card.json")
+	err := os.WriteFile(path, []byte(`{
+		"encoding": "latin1",
+		"delimiter": ";",
+		"skip_rows": 1,
+		"header": true,
+		"date": "Booked",
+		"date_formats": ["2006-01-02", "02.01.2006"],
+		"description": 2,
+		"amount": "Betrag",
+		"sign": "positive"
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := firefly.Match{
+		AccountID:       1,
+		File:            []byte("Card statement\nBooked;Text;Betrag\n05.04.2024;CAF\xc9;2.50\n"),
+		Profile:         path,
+		KeepDescription: true,
+		Tag:             "gdpr",
+		NonInteractive:  true,
+		OnNone:          "fail",
+		OnMultiple:      "fail",
+	}
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Transaction(card); !slices.Contains(g.Transactions[0].Tags, "gdpr") {
+		t.Errorf("transaction not matched: %+v", g.Transactions[0])
+	}
+
+	m.Profile, m.Tag = "monzo", "monzo"
+	m.File = []byte("Transaction ID,Date,Name,Amount,Currency,Local amount,Local currency\ntx_1,05/04/2024,Café,-2.50,GBP,-2.50,GBP\n")
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Transaction(card); !slices.Contains(g.Transactions[0].Tags, "monzo") {
+		t.Errorf("transaction not matched with monzo profile: %+v", g.Transactions[0])
+	}
+
+	m.File = []byte("Transaction ID,Date,Payee,Amount,Currency,Local amount,Local currency\ntx_1,05/04/2024,Café,-2.50,GBP,-2.50,GBP\n")
+	if err := m.Run(t.Context(), s.API()); err == nil || !strings.Contains(err.Error(), `missing columns "Name"; found`) {
+		t.Errorf("got error %v, want missing Name column", err)
+	}
+}
+
+func TestMatchForeign(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	hotel := seed(s, firefly.Transaction{
+		Date:                time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Amount:              money.MustParse("86.50", "GBP"),
+		CurrencyCode:        "GBP",
+		ForeignAmount:       money.MustParse("100.00", "EUR"),
+		ForeignCurrencyCode: "EUR",
+		Description:         "Hotel",
+	})[0]
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"contains": "TAXI", "account_id": 6}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMatch(t, "01 Jun 24,HOTEL,-87.10,GBP,100.00,EUR\n02 Jun 24,TAXI,-1500,GBP,1500,JPY\n")
+	m.DateFormat = ""
+	m.ColCurrency, m.ColForeignAmount, m.ColForeignCurrency = 4, 5, 6
+	m.Mapping = path
+	m.NonInteractive, m.OnNone, m.OnMultiple = true, "create", "fail"
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Transaction(hotel); !slices.Contains(g.Transactions[0].Tags, "gdpr") {
+		t.Errorf("hotel not matched by foreign amount: %+v", g.Transactions[0])
+	}
+	ids := s.Transactions()
+	if len(ids) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(ids))
+	}
+	g, _ := s.Transaction(ids[1])
+	taxi := g.Transactions[0]
+	if taxi.Amount.String() != "1500.00" || taxi.ForeignAmount.String() != "1500" || taxi.ForeignCurrencyCode != "JPY" {
+		t.Errorf("created %s, foreign %s %s", taxi.Amount, taxi.ForeignAmount, taxi.ForeignCurrencyCode)
+	}
+}
+
+func TestMatchSplit(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	split := func(desc, amount string) firefly.Transaction {
+		return firefly.Transaction{
+			Type:          "withdrawal",
+			Date:          time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+			Amount:        money.MustParse(amount, ""),
+			Description:   desc,
+			SourceID:      1,
+			DestinationID: 5,
+		}
+	}
+	shop := s.AddTransaction(firefly.TransactionGroup{
+		GroupTitle:   "Supermarket",
+		Transactions: []firefly.Transaction{split("Food", "20.00"), split("Cleaning", "15.00")},
+	})
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(path, []byte(`{"rules": [{"contains": "BOOKSHOP", "account_id": 6, "splits": [
+		{"amount": "10.00", "category": "Books"},
+		{"category": "Stationery", "tags": ["office"]}
+	]}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMatch(t, "01 Jul 24,SUPERMARKET,-35.00\n02 Jul 24,BOOKSHOP,-25.00\n")
+	m.DateFormat = ""
+	m.Mapping = path
+	m.NonInteractive, m.OnNone, m.OnMultiple = true, "fail", "fail"
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	g, _ := s.Transaction(shop)
+	for _, tr := range g.Transactions {
+		if !slices.Contains(tr.Tags, "gdpr") {
+			t.Errorf("split %q not matched", tr.Description)
+		}
+	}
+
+	ids := s.Transactions()
+	if len(ids) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(ids))
+	}
+	g, _ = s.Transaction(ids[1])
+	var got []string
+	for _, tr := range g.Transactions {
+		got = append(got, fmt.Sprintf("%s %s %d %v", tr.Amount, tr.Category, tr.DestinationID, tr.Tags))
+	}
+	want := []string{"10.00 Books 6 [gdpr]", "15.00 Stationery 6 [gdpr office]"}
+	if g.GroupTitle != "BOOKSHOP" || !slices.Equal(got, want) {
+		t.Errorf("created %q %q, want %q", g.GroupTitle, got, want)
+	}
+}
@@ -0,0 +1,224 @@
+package firefly
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+// Object is a resource as returned by Firefly, pairing its ID with the
+// attributes of type T.
+type Object[T any] struct {
+	Type       string    `json:"type,omitzero"`
+	ID         StringInt `json:"id"`
+	Attributes T         `json:"attributes"`
+}
+
+// About is the response of /about.
+type About struct {
+	Version    string `json:"version"`
+	APIVersion string `json:"api_version"`
+	PHPVersion string `json:"php_version"`
+	OS         string `json:"os"`
+	Driver     string `json:"driver"`
+}
+
+// TransactionGroup is a transaction as Firefly stores it, holding one or
+// more splits. It is also the body for creating and updating transactions.
+type TransactionGroup struct {
+	GroupTitle           string        `json:"group_title,omitzero"`
+	ErrorIfDuplicateHash bool          `json:"error_if_duplicate_hash,omitzero"`
+	ApplyRules           bool          `json:"apply_rules,omitzero"`
+	Transactions         []Transaction `json:"transactions"`
+}
+
+// Transaction is a single split (journal) of a [TransactionGroup].
+type Transaction struct {
+	ID                  StringInt   `json:"transaction_journal_id,omitzero"`
+	Type                string      `json:"type"`
+	Date                time.Time   `json:"date"`
+	Amount              money.Money `json:"amount"`
+	CurrencyCode        string      `json:"currency_code,omitzero"`
+	ForeignAmount       money.Money `json:"foreign_amount,omitzero"`
+	ForeignCurrencyCode string      `json:"foreign_currency_code,omitzero"`
+	Description         string      `json:"description"`
+	Source              string      `json:"source_name,omitzero"`
+	SourceID            StringInt   `json:"source_id,omitzero"`
+	SourceType          string      `json:"source_type,omitzero"`
+	Destination         string      `json:"destination_name,omitzero"`
+	DestinationID       StringInt   `json:"destination_id,omitzero"`
+	DestinationType     string      `json:"destination_type,omitzero"`
+	CategoryID          StringInt   `json:"category_id,omitzero"`
+	Category            string      `json:"category_name,omitzero"`
+	BudgetID            StringInt   `json:"budget_id,omitzero"`
+	Budget              string      `json:"budget_name,omitzero"`
+	Tags                []string    `json:"tags,omitzero"`
+	Notes               string      `json:"notes,omitzero"`
+	ExternalID          string      `json:"external_id,omitzero"`
+	InternalReference   string      `json:"internal_reference,omitzero"`
+	Reconciled          bool        `json:"reconciled,omitzero"`
+	BookDate            time.Time   `json:"book_date,omitzero"`
+	ProcessDate         time.Time   `json:"process_date,omitzero"` // start
+	PaymentDate         time.Time   `json:"payment_date,omitzero"` // end
+	InterestDate        time.Time   `json:"interest_date,omitzero"`
+	DueDate             time.Time   `json:"due_date,omitzero"`
+	InvoiceDate         time.Time   `json:"invoice_date,omitzero"`
+}
+
+func (t Transaction) String() string {
+	return fmt.Sprintf("%d %s %q (%s → %s) %s", t.ID, t.Date.Format("02 Jan 2006"), t.Description, t.Source, t.Destination, t.Amount)
+}
+
+// UnmarshalJSONFrom decodes t, parsing amounts in their currencies.
+func (t *Transaction) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	v, err := d.ReadValue()
+	if err != nil {
+		return err
+	}
+	var codes struct {
+		CurrencyCode        string `json:"currency_code"`
+		ForeignCurrencyCode string `json:"foreign_currency_code"`
+	}
+	if err := json.Unmarshal(v, &codes); err != nil {
+		return err
+	}
+	type plain Transaction
+	p := plain{
+		Amount:        money.Money{Currency: codes.CurrencyCode},
+		ForeignAmount: money.Money{Currency: codes.ForeignCurrencyCode},
+	}
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	*t = Transaction(p)
+	return nil
+}
+
+// Account is an asset, expense, revenue or liability account.
+type Account struct {
+	Name               string      `json:"name"`
+	Type               string      `json:"type"`
+	AccountRole        string      `json:"account_role,omitzero"`
+	Active             bool        `json:"active,omitzero"`
+	IBAN               string      `json:"iban,omitzero"`
+	AccountNumber      string      `json:"account_number,omitzero"`
+	CurrencyCode       string      `json:"currency_code,omitzero"`
+	CurrentBalance     money.Money `json:"current_balance,omitzero"`
+	CurrentBalanceDate time.Time   `json:"current_balance_date,omitzero"`
+	OpeningBalance     money.Money `json:"opening_balance,omitzero"`
+	OpeningBalanceDate time.Time   `json:"opening_balance_date,omitzero"`
+	Notes              string      `json:"notes,omitzero"`
+}
+
+// UnmarshalJSONFrom decodes a, parsing balances in its currency.
+func (a *Account) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	v, err := d.ReadValue()
+	if err != nil {
+		return err
+	}
+	var code struct {
+		CurrencyCode string `json:"currency_code"`
+	}
+	if err := json.Unmarshal(v, &code); err != nil {
+		return err
+	}
+	type plain Account
+	p := plain{
+		CurrentBalance: money.Money{Currency: code.CurrencyCode},
+		OpeningBalance: money.Money{Currency: code.CurrencyCode},
+	}
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	*a = Account(p)
+	return nil
+}
+
+// Tag is a tag that can be applied to transactions.
+type Tag struct {
+	Tag         string `json:"tag"`
+	Date        string `json:"date,omitzero"`
+	Description string `json:"description,omitzero"`
+}
+
+// Category is a transaction category.
+type Category struct {
+	Name  string `json:"name"`
+	Notes string `json:"notes,omitzero"`
+}
+
+// Budget is a budget transactions can be assigned to.
+type Budget struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active,omitzero"`
+	Notes  string `json:"notes,omitzero"`
+}
+
+// TransactionLink links two transaction journals with a [LinkType].
+type TransactionLink struct {
+	LinkTypeID StringInt `json:"link_type_id"`
+	InwardID   StringInt `json:"inward_id"`
+	OutwardID  StringInt `json:"outward_id"`
+	Notes      string    `json:"notes,omitzero"`
+}
+
+// LinkType describes the relationship of a [TransactionLink], such as
+// "Reimbursement", read as "inward" from one side and "outward" from the
+// other.
+type LinkType struct {
+	Name     string `json:"name"`
+	Inward   string `json:"inward"`
+	Outward  string `json:"outward"`
+	Editable bool   `json:"editable,omitzero"`
+}
+
+// Rule is a rule applied by Firefly to transactions.
+type Rule struct {
+	Title          string        `json:"title"`
+	Description    string        `json:"description,omitzero"`
+	RuleGroupID    StringInt     `json:"rule_group_id,omitzero"`
+	Order          int           `json:"order,omitzero"`
+	Trigger        string        `json:"trigger,omitzero"`
+	Active         bool          `json:"active,omitzero"`
+	Strict         bool          `json:"strict,omitzero"`
+	StopProcessing bool          `json:"stop_processing,omitzero"`
+	Triggers       []RuleTrigger `json:"triggers,omitzero"`
+	Actions        []RuleAction  `json:"actions,omitzero"`
+}
+
+// RuleTrigger is a condition of a [Rule].
+type RuleTrigger struct {
+	Type           string `json:"type"`
+	Value          string `json:"value"`
+	Order          int    `json:"order,omitzero"`
+	Active         bool   `json:"active,omitzero"`
+	Prohibited     bool   `json:"prohibited,omitzero"`
+	StopProcessing bool   `json:"stop_processing,omitzero"`
+}
+
+// RuleAction is an action taken by a [Rule].
+type RuleAction struct {
+	Type           string `json:"type"`
+	Value          string `json:"value,omitzero"`
+	Order          int    `json:"order,omitzero"`
+	Active         bool   `json:"active,omitzero"`
+	StopProcessing bool   `json:"stop_processing,omitzero"`
+}
+
+// Attachment is a file attached to a Firefly object such as a transaction
+// journal.
+type Attachment struct {
+	Filename       string    `json:"filename"`
+	Title          string    `json:"title,omitzero"`
+	Notes          string    `json:"notes,omitzero"`
+	AttachableType string    `json:"attachable_type"`
+	AttachableID   StringInt `json:"attachable_id"`
+	Mime           string    `json:"mime,omitzero"`
+	Size           int       `json:"size,omitzero"`
+	DownloadURL    string    `json:"download_url,omitzero"`
+	UploadURL      string    `json:"upload_url,omitzero"`
+}
@@ -0,0 +1,117 @@
+package firefly
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+//go:embed profiles/*.json
+var profiles embed.FS
+
+// Profile describes the layout of a bank's CSV statements.
+type Profile struct {
+	// Encoding is utf-8, the default, or latin1.
+	Encoding string `json:"encoding,omitzero"`
+	// Delimiter separates fields, by default a comma.
+	Delimiter string `json:"delimiter,omitzero"`
+	// SkipRows is the number of lines before the header or first row.
+	SkipRows int `json:"skip_rows,omitzero"`
+	// Header is set if the first row names the columns.
+	Header      bool     `json:"header,omitzero"`
+	Date        Column   `json:"date"`
+	DateFormats []string `json:"date_formats"`
+	Description Column   `json:"description"`
+	// Amount holds deposits and, unless Withdrawal is set, withdrawals.
+	Amount     Column `json:"amount"`
+	Withdrawal Column `json:"withdrawal,omitzero"`
+	// Sign is the sign of withdrawals in Amount: negative, the default, or
+	// positive.
+	Sign string `json:"sign,omitzero"`
+	// Currency holds the currency of Amount, and ForeignAmount and
+	// ForeignCurrency the amount in the original currency of card spends
+	// abroad.
+	Currency        Column `json:"currency,omitzero"`
+	ForeignAmount   Column `json:"foreign_amount,omitzero"`
+	ForeignCurrency Column `json:"foreign_currency,omitzero"`
+	// Balance holds the account's balance after each row.
+	Balance Column `json:"balance,omitzero"`
+}
+
+// Column identifies a column by its one-indexed number or its name in the
+// header, given in JSON as a number or a string.
+type Column struct {
+	Index int
+	Name  string
+}
+
+func (c *Column) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	tok, err := d.ReadToken()
+	if err != nil {
+		return err
+	}
+	switch tok.Kind() {
+	case '0':
+		c.Index = int(tok.Int())
+	case '"':
+		c.Name = tok.String()
+	default:
+		return fmt.Errorf("column must be a number or header name, not %s", tok.Kind())
+	}
+	return nil
+}
+
+// LoadProfile returns the built-in profile with the given name, such as
+// barclays, or else reads name as a JSON profile file.
+func LoadProfile(name string) (Profile, error) {
+	var p Profile
+	b, err := profiles.ReadFile("profiles/" + name + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		b, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("profile %s: %w", name, err)
+	}
+	switch p.Sign {
+	case "", "negative", "positive":
+	default:
+		return p, fmt.Errorf("profile %s: sign must be negative or positive, not %q", name, p.Sign)
+	}
+	if utf8.RuneCountInString(p.Delimiter) > 1 {
+		return p, fmt.Errorf("profile %s: delimiter must be a single character", name)
+	}
+	return p, nil
+}
+
+// decode returns b, less the skipped lines, as UTF-8.
+func (p Profile) decode(b []byte) ([]byte, error) {
+	for range p.SkipRows {
+		_, rest, ok := bytes.Cut(b, []byte{'\n'})
+		if !ok {
+			return nil, nil
+		}
+		b = rest
+	}
+	switch strings.ToLower(p.Encoding) {
+	case "", "utf-8", "utf8":
+		return b, nil
+	case "latin1", "iso-8859-1":
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return []byte(string(r)), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", p.Encoding)
+}
@@ -0,0 +1,374 @@
+package firefly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+// Reconcile checks the Running balances of a statement against Firefly's
+// balance of each account at the end of each date, and lists the
+// transactions missing from or extra in Firefly around the first date they
+// diverge.
+type Reconcile struct {
+	File      []byte `arg:"" type:"filecontent" help:"CSV file of Account,Date,Description,Payments,Receipts,Running rows, as written by barclays and reexport"`
+	AccountID int    `short:"a" help:"Asset account ID of every row, overriding the Account column"`
+}
+
+// day is the rows of a statement for an account on a date.
+type day struct {
+	date time.Time
+	rows []statementRow
+}
+
+type statementRow struct {
+	line
+	running string
+}
+
+// signed returns the amount of li, negative for payments.
+func (li line) signed() money.Money {
+	if li.payment {
+		return li.amount.Neg()
+	}
+	return li.amount
+}
+
+func (rc Reconcile) Run(ctx context.Context, a API) error {
+	st, err := readStatement(ctx, a, rc.File, rc.AccountID)
+	if err != nil {
+		return err
+	}
+	if st.running < 0 {
+		return errors.New("statement has no Running column")
+	}
+
+	var (
+		order []int
+		days  = make(map[int][]day)
+	)
+	for row := 2; ; row++ {
+		record, err := st.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		li, err := st.parse(row, record)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		account, err := st.accountOf(record)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		if _, ok := days[account]; !ok {
+			order = append(order, account)
+		}
+		// balances change on the statement's date, not the process date
+		// given in some descriptions
+		ds := days[account]
+		if len(ds) == 0 || !ds[len(ds)-1].date.Equal(li.paymentDate) {
+			ds = append(ds, day{date: li.paymentDate})
+		}
+		ds[len(ds)-1].rows = append(ds[len(ds)-1].rows, statementRow{li, strings.TrimSpace(record[st.running])})
+		days[account] = ds
+	}
+
+	var diverged int
+	for _, account := range order {
+		ok, err := rc.reconcile(ctx, a, account, days[account])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			diverged++
+		}
+	}
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d accounts diverge", diverged, len(order))
+	}
+	return nil
+}
+
+// reconcile compares the balance at the end of each of days with Firefly's,
+// reporting whether they all agree.
+func (rc Reconcile) reconcile(ctx context.Context, a API, account int, days []day) (bool, error) {
+	acc, err := a.Account(ctx, account)
+	if err != nil {
+		return false, err
+	}
+	currency := acc.Attributes.CurrencyCode
+	l := slog.With(slog.Int("account", account))
+
+	var (
+		checked time.Time
+		last    money.Money
+	)
+	for i, d := range days {
+		balance, ok, err := d.balance(currency)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		if checked.IsZero() {
+			// the opening balance is the first balance given less the
+			// rows before it
+			opening := balance
+			for _, r := range d.rows {
+				if !opening.Comparable(r.amount) {
+					return false, fmt.Errorf("row %d: amount in %s, account %d in %s", r.row, r.amount.Currency, account, currency)
+				}
+				opening = opening.Sub(r.signed())
+			}
+			ok, err := rc.compare(ctx, a, account, d.date.AddDate(0, 0, -1), opening, days, i, "the opening balance")
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		ok, err = rc.compare(ctx, a, account, d.date, balance, days, i, fmt.Sprintf("row %d", d.rows[0].row))
+		if err != nil || !ok {
+			return false, err
+		}
+		l.Info("balance agrees", slog.String("date", d.date.Format(time.DateOnly)), slog.String("balance", balance.String()))
+		checked, last = d.date, balance
+	}
+	if checked.IsZero() {
+		fmt.Printf("account %d: no balances to check\n", account)
+	} else {
+		fmt.Printf("account %d: balances agree through %s (%s)\n", account, checked.Format("02 Jan 2006"), last)
+	}
+	return true, nil
+}
+
+// balance returns the last Running balance given on d, if any.
+func (d day) balance(currency string) (money.Money, bool, error) {
+	for _, r := range slices.Backward(d.rows) {
+		if r.running == "" {
+			continue
+		}
+		m, err := money.Parse(r.running, currency)
+		if err != nil {
+			return m, false, fmt.Errorf("row %d: running balance: %w", r.row, err)
+		}
+		return m, true, nil
+	}
+	return money.Money{}, false, nil
+}
+
+// compare checks balance against Firefly's at the end of date, where the
+// balances diverge at where, and if they differ reports the transactions
+// around days[i] that explain it.
+func (rc Reconcile) compare(ctx context.Context, a API, account int, date time.Time, balance money.Money, days []day, i int, where string) (bool, error) {
+	o, err := a.AccountOn(ctx, account, date)
+	if err != nil {
+		return false, err
+	}
+	ledger := o.Attributes.CurrentBalance
+	if !ledger.Comparable(balance) {
+		return false, fmt.Errorf("account %d: balance in %s, statement in %s", account, ledger.Currency, balance.Currency)
+	}
+	if ledger.Units == balance.Units {
+		return true, nil
+	}
+	fmt.Printf("account %d: balances diverge on %s at %s: statement %s, Firefly %s (difference %s)\n",
+		account, date.Format("02 Jan 2006"), where, balance, ledger, balance.Sub(ledger))
+
+	start, end := days[i].date.AddDate(0, 0, -1), days[i].date.AddDate(0, 0, 1)
+	var rows []statementRow
+	for _, d := range days {
+		if !d.date.Before(start) && !d.date.After(end) {
+			rows = append(rows, d.rows...)
+		}
+	}
+	q := url.Values{"start": {start.Format(time.DateOnly)}, "end": {end.Format(time.DateOnly)}}
+	groups, err := Collect(a.AccountTransactions(ctx, account, q))
+	if err != nil {
+		return false, err
+	}
+	missing, extra, moved := diff(account, rows, groups)
+	for _, r := range missing {
+		fmt.Printf("\tmissing from Firefly: row %d %s %q %s\n", r.row, r.rawDate, r.description, r.signed())
+	}
+	for _, t := range extra {
+		fmt.Printf("\textra in Firefly: %s\n", t)
+	}
+	for _, m := range moved {
+		fmt.Printf("\tdated differently: row %d %s %q, Firefly %s\n", m.row.row, m.row.rawDate, m.row.description, m.t)
+	}
+	if len(missing)+len(extra)+len(moved) == 0 {
+		fmt.Printf("\tthe transactions from %s to %s agree, so the balances diverge earlier\n", start.Format("02 Jan 2006"), end.Format("02 Jan 2006"))
+	}
+	return false, nil
+}
+
+type movedRow struct {
+	row statementRow
+	t   Transaction
+}
+
+// diff pairs statement rows with the splits of groups to or from account
+// by amount, preferring splits on the same date, and returns the rows and
+// splits left unpaired and the pairs on different dates.
+func diff(account int, rows []statementRow, groups []Object[TransactionGroup]) (missing []statementRow, extra []Transaction, moved []movedRow) {
+	var splits []Transaction
+	for _, g := range groups {
+		for _, t := range g.Attributes.Transactions {
+			switch account {
+			case int(t.SourceID):
+				t.Amount = t.Amount.Abs().Neg()
+			case int(t.DestinationID):
+				t.Amount = t.Amount.Abs()
+			default:
+				continue
+			}
+			splits = append(splits, t)
+		}
+	}
+	paired := make([]bool, len(splits))
+	pair := func(r statementRow, sameDate bool) int {
+		for j, t := range splits {
+			date := t.Date.Format(time.DateOnly)
+			if !paired[j] && t.Amount.Comparable(r.amount) && t.Amount.Units == r.signed().Units &&
+				(!sameDate || date == r.date.Format(time.DateOnly) || date == r.paymentDate.Format(time.DateOnly)) {
+				paired[j] = true
+				return j
+			}
+		}
+		return -1
+	}
+	var rest []statementRow
+	for _, r := range rows {
+		if pair(r, true) < 0 {
+			rest = append(rest, r)
+		}
+	}
+	for _, r := range rest {
+		if j := pair(r, false); j >= 0 {
+			moved = append(moved, movedRow{r, splits[j]})
+		} else {
+			missing = append(missing, r)
+		}
+	}
+	for j, t := range splits {
+		if !paired[j] {
+			extra = append(extra, t)
+		}
+	}
+	return missing, extra, moved
+}
+
+// period is the part of a statement a match session covers.
+type period struct {
+	start, end time.Time
+	// closing is the balance given by the last row with one.
+	closing     money.Money
+	closingDate time.Time
+	hasClosing  bool
+	// groups are the transaction groups matched or created, and open the
+	// number of rows left unresolved.
+	groups []int
+	open   int
+}
+
+func (p *period) add(li line, e entry) {
+	d := li.paymentDate
+	if p.start.IsZero() || d.Before(p.start) {
+		p.start = d
+	}
+	if d.After(p.end) {
+		p.end = d
+	}
+	if li.hasBalance && !d.Before(p.closingDate) {
+		p.closing, p.closingDate, p.hasClosing = li.balance, d, true
+	}
+	switch e.Outcome {
+	case outcomeMatched, outcomeCreated:
+		p.groups = append(p.groups, e.ID)
+	default:
+		p.open++
+	}
+}
+
+// close marks the transactions of p reconciled once every row is resolved
+// and the closing balance agrees with Firefly's, or differs by what a
+// reconciliation transaction makes up, returning a summary of the period.
+func (m Match) close(ctx context.Context, a API, r resolver, p period) (string, error) {
+	switch {
+	case m.Reconcile != "ask" && m.Reconcile != "yes" || len(p.groups) == 0 || !p.hasClosing:
+		return "", nil
+	case p.open > 0:
+		return fmt.Sprintf("not reconciling: %d rows left unresolved", p.open), nil
+	}
+	dates := fmt.Sprintf("%s to %s", p.start.Format("02 Jan 2006"), p.end.Format("02 Jan 2006"))
+	o, err := a.AccountOn(ctx, m.AccountID, p.closingDate)
+	if err != nil {
+		return "", err
+	}
+	if !p.closing.Comparable(o.Attributes.CurrentBalance) {
+		return "", fmt.Errorf("closing balance in %s, account %d in %s", p.closing.Currency, m.AccountID, o.Attributes.CurrentBalance.Currency)
+	}
+	difference := p.closing.Sub(o.Attributes.CurrentBalance)
+	if !difference.IsZero() && !m.ReconcileDifference {
+		return fmt.Sprintf("not reconciling %s: closing balance %s differs from Firefly's %s by %s",
+			dates, p.closing, o.Attributes.CurrentBalance, difference), nil
+	}
+	groups := slices.Compact(slices.Sorted(slices.Values(p.groups)))
+	if m.Reconcile == "ask" {
+		title := fmt.Sprintf("Mark the %d transactions from %s reconciled?", len(groups), dates)
+		if !difference.IsZero() {
+			title += fmt.Sprintf(" A reconciliation of %s will be created.", difference)
+		}
+		if ok, err := r.confirm(title); err != nil || !ok {
+			return "", err
+		}
+	}
+
+	for _, id := range groups {
+		g, err := a.Transaction(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		splits := g.Attributes.Transactions
+		for i := range splits {
+			splits[i].Reconciled = true
+		}
+		if _, err := a.UpdateTransaction(ctx, id, TransactionGroup{GroupTitle: g.Attributes.GroupTitle, Transactions: splits}); err != nil {
+			return "", fmt.Errorf("reconciling transaction %d: %w", id, err)
+		}
+	}
+	summary := fmt.Sprintf("reconciled %s: %d transactions, closing balance %s", dates, len(groups), p.closing)
+
+	if !difference.IsZero() {
+		t := Transaction{
+			Type:         "reconciliation",
+			Date:         p.closingDate,
+			Amount:       difference.Abs(),
+			CurrencyCode: difference.Currency,
+			Description:  "Reconciliation " + dates,
+			Reconciled:   true,
+		}
+		if difference.Sign() > 0 {
+			t.DestinationID = StringInt(m.AccountID)
+		} else {
+			t.SourceID = StringInt(m.AccountID)
+		}
+		out, err := a.CreateTransaction(ctx, TransactionGroup{Transactions: []Transaction{t}})
+		if err != nil {
+			return summary, fmt.Errorf("creating reconciliation: %w", err)
+		}
+		summary += fmt.Sprintf(", with a reconciliation of %s (#%d)", difference, out.ID)
+	}
+	return summary, nil
+}
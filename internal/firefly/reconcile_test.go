@@ -0,0 +1,107 @@
+package firefly_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestReconcile(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	current := firefly.StringInt(s.AddAccount(firefly.Account{Name: "Current", Type: "asset", AccountNumber: "12345678", OpeningBalance: money.MustParse("100.00", "")}))
+	shop := firefly.StringInt(s.AddAccount(firefly.Account{Name: "Shop", Type: "expense"}))
+	employer := firefly.StringInt(s.AddAccount(firefly.Account{Name: "Employer", Type: "revenue"}))
+	seed(s,
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("12.34", ""), Description: "TESCO", SourceID: current, DestinationID: shop},
+		firefly.Transaction{Type: "deposit", Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("1000.00", ""), Description: "SALARY", SourceID: employer, DestinationID: current},
+		firefly.Transaction{Type: "deposit", Date: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("5.00", ""), Description: "REFUND", SourceID: employer, DestinationID: current},
+		firefly.Transaction{Date: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("3.00", ""), Description: "CAFE", SourceID: current, DestinationID: shop},
+	)
+
+	rc := firefly.Reconcile{File: []byte(`Account,Date,Description,Payments,Receipts,Running
+20-00-00 12345678,01 Jan 24,TESCO,12.34,,87.66
+20-00-00 12345678,02 Jan 24,SALARY,,1000.00,1087.66
+20-00-00 12345678,03 Jan 24,CORNER SHOP,2.00,,
+20-00-00 12345678,04 Jan 24,CAFE,3.00,,1082.66
+`)}
+	out, err := stdout(t, func() error { return rc.Run(t.Context(), s.API()) })
+	if err == nil {
+		t.Error("got no error for diverging balances")
+	}
+	for _, want := range []string{
+		"balances diverge on 04 Jan 2024 at row 5: statement 1082.66, Firefly 1089.66 (difference -7.00)",
+		`missing from Firefly: row 4 03 Jan 24 "CORNER SHOP" -2.00`,
+		`extra in Firefly: `,
+		`"REFUND"`,
+		`dated differently: row 5 04 Jan 24 "CAFE"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	rc.File = rc.File[:strings.Index(string(rc.File), "20-00-00 12345678,03")]
+	if out, err := stdout(t, func() error { return rc.Run(t.Context(), s.API()) }); err != nil || !strings.Contains(out, "balances agree through 02 Jan 2024 (1087.66)") {
+		t.Errorf("got %v:\n%s", err, out)
+	}
+}
+
+func TestMatchReconcile(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	current := s.AddAccount(firefly.Account{Name: "Current", Type: "asset", OpeningBalance: money.MustParse("100.00", "")})
+	shop := firefly.StringInt(s.AddAccount(firefly.Account{Name: "Shop", Type: "expense"}))
+	ids := seed(s,
+		firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("12.34", ""), Description: "TESCO", SourceID: firefly.StringInt(current), DestinationID: shop},
+		firefly.Transaction{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("2.00", ""), Description: "CORNER SHOP", SourceID: firefly.StringInt(current), DestinationID: shop},
+	)
+
+	m := firefly.Match{
+		AccountID: current,
+		File: []byte(`Account,Date,Description,Payments,Receipts,Running
+20-00-00 12345678,01 Jan 24,TESCO,12.34,,87.66
+20-00-00 12345678,02 Jan 24,CORNER SHOP,2.00,,85.00
+`),
+		Profile:         "barclays",
+		KeepDescription: true,
+		Tag:             "gdpr",
+		NonInteractive:  true,
+		OnNone:          "skip",
+		OnMultiple:      "skip",
+		Journal:         t.TempDir(),
+		Reconcile:       "yes",
+	}
+	out, err := stdout(t, func() error { return m.Run(t.Context(), s.API()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "closing balance 85.00 differs from Firefly's 85.66 by -0.66"; !strings.Contains(out, want) {
+		t.Errorf("output does not contain %q:\n%s", want, out)
+	}
+	if g, _ := s.Transaction(ids[0]); g.Transactions[0].Reconciled {
+		t.Error("reconciled despite differing balances")
+	}
+
+	m.ReconcileDifference = true
+	out, err = stdout(t, func() error { return m.Run(t.Context(), s.API()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "reconciled 01 Jan 2024 to 02 Jan 2024: 2 transactions, closing balance 85.00, with a reconciliation of -0.66"; !strings.Contains(out, want) {
+		t.Errorf("output does not contain %q:\n%s", want, out)
+	}
+	for _, id := range ids {
+		if g, _ := s.Transaction(id); !g.Transactions[0].Reconciled {
+			t.Errorf("transaction %d not reconciled", id)
+		}
+	}
+	all := s.Transactions()
+	if g, _ := s.Transaction(all[len(all)-1]); g.Transactions[0].Type != "reconciliation" || int(g.Transactions[0].SourceID) != current {
+		t.Errorf("created %+v", g.Transactions[0])
+	}
+}
@@ -0,0 +1,95 @@
+package firefly
+
+import "fmt"
+
+// resolver decides statement rows that cannot be matched automatically.
+type resolver interface {
+	// none returns the ID of a transaction group to match when the search
+	// found none, or zero to create a new transaction instead.
+	none(title string) (int, error)
+	// pick returns the option to match, or an index of -1 to create a new
+	// transaction instead.
+	pick(title string, li line, options []candidate) (choice, error)
+	// account returns the ID of the opposing account for a new transaction,
+	// which may be a new account of accountType, expense or revenue.
+	account(title, accountType string) (int, error)
+	// text returns value, possibly edited.
+	text(title, value string) (string, error)
+	// correct returns a new value for a field Firefly rejected as value.
+	correct(title, value string) (string, error)
+	// confirm reports whether to go ahead with what title describes.
+	confirm(title string) (bool, error)
+}
+
+// choice is the option picked for a row: the index of one of the options,
+// or -1 to create a new transaction, or else the ID of a transaction group
+// given by the user. A description, if set, replaces the matched
+// transaction's.
+type choice struct {
+	index       int
+	id          int
+	description string
+}
+
+// unresolvedError is returned for a row that a policy leaves for later
+// review rather than failing the session.
+type unresolvedError struct{ reason string }
+
+func (e *unresolvedError) Error() string { return e.reason }
+
+// policy resolves rows without prompting, for non-interactive sessions.
+type policy struct {
+	onNone, onMultiple string
+}
+
+func (p policy) none(title string) (int, error) {
+	switch p.onNone {
+	case "create":
+		return 0, nil
+	case "fail":
+		return 0, fmt.Errorf("%s: no transaction found", title)
+	}
+	return 0, &unresolvedError{"no transaction found"}
+}
+
+func (p policy) pick(title string, _ line, options []candidate) (choice, error) {
+	if len(options) == 0 {
+		return choice{}, &unresolvedError{"no candidate transaction"}
+	}
+	found := fmt.Sprintf("%d transactions found", len(options))
+	if len(options) == 1 {
+		found = "1 transaction found, not close enough to accept"
+	}
+	switch p.onMultiple {
+	case "best":
+		return choice{index: best(options)}, nil
+	case "create":
+		return choice{index: -1}, nil
+	case "fail":
+		return choice{}, fmt.Errorf("%s: %s", title, found)
+	}
+	return choice{}, &unresolvedError{found}
+}
+
+func (policy) account(_, _ string) (int, error) {
+	return 0, &unresolvedError{"no mapped account"}
+}
+
+func (policy) text(_, value string) (string, error) { return value, nil }
+
+func (policy) correct(_, _ string) (string, error) {
+	return "", &unresolvedError{"field rejected"}
+}
+
+func (policy) confirm(string) (bool, error) { return false, nil }
+
+// best returns the index of the highest scoring option.
+func best(options []candidate) int {
+	i := 0
+	for j, c := range options {
+		if c.score > options[i].score {
+			i = j
+		}
+	}
+	return i
+}
@@ -0,0 +1,28 @@
+package firefly
+
+import "testing"
+
+func TestPolicyPick(t *testing.T) {
+	one := []candidate{{groupID: 1, score: 0.5}}
+	two := []candidate{{groupID: 1, score: 0.5}, {groupID: 2, score: 0.9}}
+	for _, tt := range []struct {
+		onMultiple string
+		options    []candidate
+		want       choice
+		err        string
+	}{
+		{"best", nil, choice{}, "no candidate transaction"},
+		{"create", nil, choice{}, "no candidate transaction"},
+		{"best", one, choice{index: 0}, ""},
+		{"create", one, choice{index: -1}, ""},
+		{"skip", one, choice{}, "1 transaction found, not close enough to accept"},
+		{"fail", one, choice{}, "row 1: 1 transaction found, not close enough to accept"},
+		{"best", two, choice{index: 1}, ""},
+		{"skip", two, choice{}, "2 transactions found"},
+	} {
+		got, err := policy{onMultiple: tt.onMultiple}.pick("row 1", line{}, tt.options)
+		if got != tt.want || tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s of %d: got %+v, %v, want %+v, %s", tt.onMultiple, len(tt.options), got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package firefly
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/v2/textinput"
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/x/term"
+)
+
+// review resolves rows by asking the user in a single Bubble Tea program
+// that runs for the whole session, showing each row with the candidates
+// found for it and the progress of the session. On a terminal the program
+// starts with the session, and otherwise once there is a question.
+//
+// Opposing accounts are picked from accounts, if set, or else given by ID.
+type review struct {
+	m        *reviewModel
+	accounts *accounts
+	opts     []tea.ProgramOption
+	p        *tea.Program
+	done     chan struct{}
+	err      error
+}
+
+func newReview(rows int, accounts *accounts, opts ...tea.ProgramOption) *review {
+	input := textinput.New()
+	input.SetWidth(60)
+	return &review{
+		m: &reviewModel{
+			rows: rows, noun: "row", outcomes: []string{outcomeMatched, outcomeCreated, outcomeSkipped},
+			tally: make(map[string]int), input: input,
+		},
+		accounts: accounts,
+		opts:     append([]tea.ProgramOption{tea.WithAltScreen()}, opts...),
+	}
+}
+
+func (r *review) run() {
+	if r.p != nil {
+		return
+	}
+	r.p = tea.NewProgram(r.m, r.opts...)
+	r.done = make(chan struct{})
+	go func() {
+		_, r.err = r.p.Run()
+		close(r.done)
+	}()
+}
+
+// send passes msg to the program, or straight to the model if the program
+// has not started.
+func (r *review) send(msg tea.Msg) {
+	if r.p == nil {
+		r.m.Update(msg)
+		return
+	}
+	r.p.Send(msg)
+}
+
+// exited returns the error the program exited with, if it has.
+func (r *review) exited() error {
+	if r.p == nil {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return cmp.Or(r.err, tea.ErrInterrupted)
+	default:
+		return nil
+	}
+}
+
+func (r *review) start(li line) error {
+	if r.p == nil && term.IsTerminal(os.Stdout.Fd()) {
+		r.run()
+	}
+	if err := r.exited(); err != nil {
+		return err
+	}
+	r.send(rowMsg(li))
+	return nil
+}
+
+func (r *review) finish(row int, outcome string) { r.send(outcomeMsg{row, outcome}) }
+
+// Write shows log output below the review, or writes it to stderr if the
+// program has not started.
+func (r *review) Write(b []byte) (int, error) {
+	if r.p == nil {
+		return os.Stderr.Write(b)
+	}
+	r.p.Send(logMsg(strings.TrimSpace(string(b))))
+	return len(b), nil
+}
+
+// Close stops the program.
+func (r *review) Close() error {
+	if r.p == nil {
+		return nil
+	}
+	r.p.Quit()
+	<-r.done
+	return r.err
+}
+
+// ask shows q and waits for the user to answer it.
+func (r *review) ask(q question) (answer, error) {
+	r.run()
+	q.reply = make(chan answer, 1)
+	r.p.Send(q)
+	select {
+	case a := <-q.reply:
+		return a, a.err
+	case <-r.done:
+		return answer{}, cmp.Or(r.err, tea.ErrInterrupted)
+	}
+}
+
+func (r *review) none(title string) (int, error) {
+	a, err := r.ask(question{kind: askNone, title: title})
+	return a.id, err
+}
+
+func (r *review) pick(title string, _ line, options []candidate) (choice, error) {
+	a, err := r.ask(question{kind: askPick, title: title, options: options})
+	return a.choice, err
+}
+
+// account asks the user to pick an account, or name a new one of the given
+// type to create.
+func (r *review) account(title, accountType string) (int, error) {
+	q := question{kind: askAccount, title: title}
+	if r.accounts != nil {
+		// accounts can only be created with a cache to add them to
+		q.accountType = accountType
+		var err error
+		if q.accounts, err = r.accounts.load(); err != nil {
+			return 0, err
+		}
+	}
+	a, err := r.ask(q)
+	if err != nil || a.id != 0 || a.value == "" {
+		return a.id, err
+	}
+	if r.accounts == nil {
+		return 0, fmt.Errorf("cannot create account %q without access to accounts", a.value)
+	}
+	id, err := r.accounts.create(a.value, accountType)
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("created account", slog.Int("id", id), slog.String("name", a.value), slog.String("type", accountType))
+	return id, nil
+}
+
+func (r *review) text(title, value string) (string, error) {
+	a, err := r.ask(question{kind: askText, title: title, value: value})
+	return a.value, err
+}
+
+func (r *review) correct(title, value string) (string, error) {
+	return r.text(title, value)
+}
+
+func (r *review) confirm(title string) (bool, error) {
+	a, err := r.ask(question{kind: askConfirm, title: title})
+	return a.yes, err
+}
+
+// duplicates asks what to do with a group of duplicates, merge, delete or
+// tag, and which of them to keep, starting from keep.
+func (r *review) duplicates(title string, group []candidate, keep int) (string, int, error) {
+	a, err := r.ask(question{kind: askDuplicates, title: title, options: group, cursor: keep})
+	return a.value, a.index, err
+}
+
+type questionKind int
+
+const (
+	askNone questionKind = iota
+	askPick
+	askAccount
+	askText
+	askConfirm
+	askDuplicates
+)
+
+// question is a decision the review asks the user to make.
+type question struct {
+	kind    questionKind
+	title   string
+	options []candidate
+	// cursor is the option selected at first.
+	cursor int
+	value  string
+	// accounts can be picked from for askAccount, or a new account of
+	// accountType created.
+	accounts    []Object[Account]
+	accountType string
+	reply       chan answer
+}
+
+// answer is the user's decision on a question. For askAccount, value is
+// the name of an account to create, and for askDuplicates the action to
+// take, keeping the option at index.
+type answer struct {
+	choice
+	value string
+	yes   bool
+	err   error
+}
+
+type (
+	rowMsg     line
+	outcomeMsg struct {
+		row     int
+		outcome string
+	}
+	logMsg string
+)
+
+type reviewModel struct {
+	rows int
+	row  int
+	// noun is what is counted by rows, and outcomes the tally shown.
+	noun     string
+	outcomes []string
+	li       line
+	tally    map[string]int
+	logs     []string
+
+	q      *question
+	cursor int
+	// matches are the indexes of the accounts matching input.
+	matches []int
+	// editing is what input is being used for: the ID to match, the
+	// description, the account or the text asked for. It is empty when
+	// input is hidden.
+	editing string
+	input   textinput.Model
+}
+
+func (m *reviewModel) Init() tea.Cmd { return nil }
+
+func (m *reviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case rowMsg:
+		m.li = line(msg)
+	case outcomeMsg:
+		m.row = max(m.row, msg.row)
+		m.tally[msg.outcome]++
+	case logMsg:
+		m.logs = append(m.logs, string(msg))
+		if len(m.logs) > 5 {
+			m.logs = m.logs[len(m.logs)-5:]
+		}
+	case question:
+		m.q, m.cursor = &msg, msg.cursor
+		switch msg.kind {
+		case askAccount:
+			m.matches = search(msg.accounts, "")
+			placeholder := "account ID"
+			if len(msg.accounts) > 0 {
+				placeholder = "search by name or IBAN"
+			}
+			return m, m.edit("account", "", placeholder)
+		case askText:
+			return m, m.edit("text", msg.value, "")
+		}
+	case tea.KeyPressMsg:
+		return m, m.key(msg)
+	}
+	if m.editing != "" {
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+func (m *reviewModel) edit(what, value, placeholder string) tea.Cmd {
+	m.editing = what
+	m.input.Reset()
+	m.input.SetValue(value)
+	m.input.Placeholder = placeholder
+	return m.input.Focus()
+}
+
+func (m *reviewModel) reply(a answer) {
+	m.q.reply <- a
+	m.q, m.editing = nil, ""
+	m.input.Blur()
+}
+
+func (m *reviewModel) key(k tea.KeyPressMsg) tea.Cmd {
+	key := k.String()
+	if key == "ctrl+c" || m.editing == "" && key == "q" {
+		return tea.Interrupt
+	}
+	if m.q == nil {
+		return nil
+	}
+	if m.editing != "" {
+		switch key {
+		case "up":
+			m.cursor = max(m.cursor-1, 0)
+		case "down":
+			m.cursor = max(min(m.cursor+1, len(m.matches)-1), 0)
+		case "ctrl+n":
+			if name := strings.TrimSpace(m.input.Value()); m.editing == "account" && name != "" && m.q.accountType != "" {
+				m.reply(answer{value: name})
+			}
+		case "enter":
+			m.submit()
+		case "esc":
+			switch m.editing {
+			case "account":
+				m.reply(answer{err: &unresolvedError{"no account given"}})
+			case "text":
+				m.reply(answer{value: m.q.value})
+			default:
+				m.editing = ""
+				m.input.Blur()
+			}
+		default:
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(k)
+			if m.editing == "account" && m.q.accounts != nil {
+				m.matches, m.cursor = search(m.q.accounts, strings.TrimSpace(m.input.Value())), 0
+			}
+			return cmd
+		}
+		return nil
+	}
+
+	if m.q.kind == askConfirm {
+		switch key {
+		case "y", "enter":
+			m.reply(answer{yes: true})
+		case "n", "esc":
+			m.reply(answer{})
+		}
+		return nil
+	}
+
+	if m.q.kind == askDuplicates {
+		actions := map[string]string{"m": "merge", "d": "delete", "t": "tag"}
+		switch key {
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = max(min(m.cursor+1, len(m.q.options)-1), 0)
+		case "m", "d", "t":
+			if m.cursor >= len(m.q.options) {
+				return nil
+			}
+			m.reply(answer{choice: choice{index: m.cursor}, value: actions[key]})
+		case "s":
+			m.reply(answer{err: &unresolvedError{"skipped"}})
+		}
+		return nil
+	}
+
+	pick := m.q.kind == askPick
+	switch key {
+	case "up", "k":
+		m.cursor = max(m.cursor-1, 0)
+	case "down", "j":
+		if pick {
+			m.cursor = max(min(m.cursor+1, len(m.q.options)-1), 0)
+		}
+	// without options, only creating or skipping the row is left
+	case "enter", "a":
+		if pick && m.cursor < len(m.q.options) {
+			m.reply(answer{choice: choice{index: m.cursor}})
+		}
+	case "c":
+		m.reply(answer{choice: choice{index: -1}})
+	case "s":
+		m.reply(answer{err: &unresolvedError{"skipped"}})
+	case "e":
+		if pick && m.cursor < len(m.q.options) {
+			return m.edit("description", m.li.description, "")
+		}
+	case "i":
+		return m.edit("id", "", "transaction ID")
+	}
+	return nil
+}
+
+func (m *reviewModel) submit() {
+	value := strings.TrimSpace(m.input.Value())
+	switch m.editing {
+	case "account":
+		if len(m.matches) > 0 {
+			m.reply(answer{choice: choice{id: int(m.q.accounts[m.matches[m.cursor]].ID)}})
+			return
+		}
+		fallthrough
+	case "id":
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			m.input.Err = fmt.Errorf("invalid ID %q", value)
+			return
+		}
+		m.reply(answer{choice: choice{id: id}})
+	case "description":
+		m.reply(answer{choice: choice{index: m.cursor, description: value}})
+	case "text":
+		m.reply(answer{value: value})
+	}
+}
+
+var (
+	bold      = lipgloss.NewStyle().Bold(true)
+	faint     = lipgloss.NewStyle().Faint(true)
+	panel     = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	highlight = lipgloss.NewStyle().Bold(true).Reverse(true)
+)
+
+func (m *reviewModel) View() string {
+	var b strings.Builder
+	b.WriteString(m.header())
+	b.WriteString("\n\n")
+	if m.li.row > 0 {
+		kind := "deposit"
+		if m.li.payment {
+			kind = "payment"
+		}
+		row := fmt.Sprintf("Row %d  %s  %s  %s %s", m.li.row, m.li.rawDate, bold.Render(m.li.description), kind, m.li.amount)
+		if !m.li.foreign.IsZero() {
+			row += fmt.Sprintf(" (%s %s)", m.li.foreign, m.li.foreign.Currency)
+		}
+		b.WriteString(row)
+		b.WriteString("\n\n")
+	}
+
+	if m.q != nil {
+		switch m.q.kind {
+		case askNone:
+			b.WriteString("No transaction found\n")
+		case askPick:
+			b.WriteString(m.candidates())
+			b.WriteString("\n")
+		case askDuplicates:
+			b.WriteString(m.q.title)
+			b.WriteString("\n")
+			b.WriteString(m.candidates())
+			b.WriteString("\n")
+		case askAccount:
+			b.WriteString(m.q.title)
+			b.WriteString("\n")
+			b.WriteString(m.accountList())
+		default:
+			b.WriteString(m.q.title)
+			b.WriteString("\n")
+		}
+		if m.editing != "" {
+			b.WriteString(m.input.View())
+			if m.input.Err != nil {
+				b.WriteString("  " + m.input.Err.Error())
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(faint.Render(m.help()))
+		b.WriteString("\n")
+	} else {
+		b.WriteString(faint.Render("matching…  q quit"))
+		b.WriteString("\n")
+	}
+
+	for _, l := range m.logs {
+		b.WriteString("\n")
+		b.WriteString(faint.Render(l))
+	}
+	return b.String()
+}
+
+func (m *reviewModel) header() string {
+	width := 30
+	percent := 0.0
+	if m.rows > 0 {
+		percent = min(float64(m.row)/float64(m.rows), 1)
+	}
+	filled := int(percent * float64(width))
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	tally := make([]string, len(m.outcomes))
+	for i, o := range m.outcomes {
+		tally[i] = fmt.Sprintf("%s %d", o, m.tally[o])
+	}
+	return fmt.Sprintf("%s %3.0f%%  %s %d of %d  %s",
+		bar, percent*100, m.noun, max(m.row, m.li.row), m.rows, strings.Join(tally, " · "))
+}
+
+func (m *reviewModel) candidates() string {
+	if m.cursor >= len(m.q.options) {
+		return "No transaction found\n"
+	}
+	var list strings.Builder
+	for i, c := range m.q.options {
+		desc := c.Description
+		if r := []rune(desc); len(r) > 28 {
+			desc = string(r[:27]) + "…"
+		}
+		item := fmt.Sprintf("%3.0f%% %s %-28s %10s", c.score*100, c.Date.Format("02 Jan 06"), desc, c.Amount)
+		if m.q.kind == askDuplicates {
+			item = fmt.Sprintf("#%-5d %s %-28s %10s", c.groupID, c.Date.Format("02 Jan 06"), desc, c.Amount)
+		}
+		if i == m.cursor {
+			item = highlight.Render(item)
+		}
+		list.WriteString(item)
+		list.WriteString("\n")
+	}
+	left := panel.Render(strings.TrimSuffix(list.String(), "\n"))
+	right := panel.Render(details(m.q.options[m.cursor]))
+	return lipgloss.JoinHorizontal(lipgloss.Top, left, right)
+}
+
+// details describes c for the user to compare with the row.
+func details(c candidate) string {
+	var b strings.Builder
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%-10s %s\n", name, value)
+		}
+	}
+	account := func(name string, id StringInt) string {
+		if id == 0 {
+			return name
+		}
+		return fmt.Sprintf("%s (%d)", name, id)
+	}
+	fmt.Fprintf(&b, "#%d %s\n", c.groupID, c.Type)
+	field("date", c.Date.Format("02 Jan 2006"))
+	amount := c.Amount.String()
+	if !c.ForeignAmount.IsZero() {
+		amount += fmt.Sprintf(" (%s %s)", c.ForeignAmount, c.ForeignCurrencyCode)
+	}
+	field("amount", amount)
+	field("from", account(c.Source, c.SourceID))
+	field("to", account(c.Destination, c.DestinationID))
+	field("category", c.Category)
+	field("budget", c.Budget)
+	field("tags", strings.Join(c.Tags, ", "))
+	field("notes", c.Notes)
+	if !c.ProcessDate.IsZero() {
+		field("processed", c.ProcessDate.Format("02 Jan 2006"))
+	}
+	if !c.PaymentDate.IsZero() {
+		field("paid", c.PaymentDate.Format("02 Jan 2006"))
+	}
+	if len(c.splits) > 1 {
+		b.WriteString("splits\n")
+		for _, t := range c.splits {
+			fmt.Fprintf(&b, "  %-24q %10s %s\n", t.Description, t.Amount, t.Category)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// accountList shows the accounts matching the search around the cursor.
+func (m *reviewModel) accountList() string {
+	const shown = 8
+	if m.q.accounts == nil {
+		return ""
+	}
+	if len(m.matches) == 0 {
+		return faint.Render("no accounts match") + "\n"
+	}
+	var b strings.Builder
+	first := min(max(m.cursor-shown/2, 0), max(len(m.matches)-shown, 0))
+	for i := first; i < min(first+shown, len(m.matches)); i++ {
+		o := m.q.accounts[m.matches[i]]
+		item := fmt.Sprintf("%6d %-32s %-8s %s", o.ID, o.Attributes.Name, o.Attributes.Type, o.Attributes.IBAN)
+		if i == m.cursor {
+			item = highlight.Render(item)
+		}
+		b.WriteString(item)
+		b.WriteString("\n")
+	}
+	if len(m.matches) > shown {
+		fmt.Fprintf(&b, "%s\n", faint.Render(fmt.Sprintf("%d of %d", m.cursor+1, len(m.matches))))
+	}
+	return b.String()
+}
+
+func (m *reviewModel) help() string {
+	if m.editing == "account" {
+		help := "↑/↓ select  enter pick  esc skip"
+		if m.q.accountType != "" {
+			help = fmt.Sprintf("↑/↓ select  enter pick  ctrl+n create %s account  esc skip", m.q.accountType)
+		}
+		return help
+	}
+	if m.editing != "" {
+		return "enter confirm  esc cancel"
+	}
+	switch m.q.kind {
+	case askNone:
+		return "c create  i match by ID  s skip  q quit"
+	case askPick:
+		return "↑/↓ select  enter accept  e accept with description  c create  i match by ID  s skip  q quit"
+	case askConfirm:
+		return "y yes  n no  q quit"
+	case askDuplicates:
+		return "↑/↓ select the one to keep  m merge into it  d delete the others  t tag all  s skip  q quit"
+	}
+	return ""
+}
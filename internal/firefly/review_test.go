@@ -0,0 +1,174 @@
+package firefly
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestReviewKeys(t *testing.T) {
+	options := []candidate{
+		{groupID: 1, score: 0.9, Transaction: Transaction{Description: "TESCO", Amount: money.MustParse("3.00", "")}},
+		{groupID: 2, score: 0.5, Transaction: Transaction{Description: "TESCO STORES", Amount: money.MustParse("3.10", "")}},
+	}
+	accounts := []Object[Account]{
+		{ID: 7, Attributes: Account{Name: "Tesco", Type: "expense", Active: true}},
+		{ID: 8, Attributes: Account{Name: "Tesco Express", Type: "expense", Active: true}},
+	}
+	for _, tt := range []struct {
+		name     string
+		kind     questionKind
+		accounts []Object[Account]
+		keys     string
+		want     answer
+		err      string
+	}{
+		{"accept", askPick, nil, "j\r", answer{choice: choice{index: 1}}, ""},
+		{"accept first", askPick, nil, "kka", answer{choice: choice{index: 0}}, ""},
+		{"create", askPick, nil, "c", answer{choice: choice{index: -1}}, ""},
+		{"edit description", askPick, nil, "e\x7f\x7f\x7f\x7f\x7fTesco\r", answer{choice: choice{description: "Tesco"}}, ""},
+		{"match by ID", askNone, nil, "ix\r\x7f42\r", answer{choice: choice{id: 42}}, ""},
+		{"skip", askNone, nil, "s", answer{}, "skipped"},
+		{"account ID", askAccount, nil, "7\r", answer{choice: choice{id: 7}}, ""},
+		{"pick account", askAccount, accounts, "exp\r", answer{choice: choice{id: 8}}, ""},
+		{"pick next account", askAccount, accounts, "tes↓\r", answer{choice: choice{id: 8}}, ""},
+		{"new account", askAccount, accounts, "Tesco Metro\x0e", answer{value: "Tesco Metro"}, ""},
+		{"no account", askAccount, nil, "\x1b", answer{}, "no account given"},
+		{"text", askText, nil, "!\r", answer{value: "TESCO!"}, ""},
+		{"confirm", askConfirm, nil, "csy", answer{yes: true}, ""},
+		{"decline", askConfirm, nil, "\x1b", answer{}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReview(10, nil)
+			r.send(rowMsg(line{row: 3, description: "TESCO", amount: money.MustParse("3.00", "")}))
+			q := question{kind: tt.kind, title: "row 3", options: options, value: "TESCO", accounts: tt.accounts, accountType: "expense", reply: make(chan answer, 1)}
+			r.send(q)
+			if !strings.Contains(r.m.View(), "row 3 of 10") {
+				t.Errorf("view does not show progress:\n%s", r.m.View())
+			}
+			for _, k := range tt.keys {
+				r.send(keyPress(k))
+			}
+			var got answer
+			select {
+			case got = <-q.reply:
+			default:
+				t.Fatal("no answer")
+			}
+			var u *unresolvedError
+			if tt.err != "" {
+				if !errors.As(got.err, &u) || u.reason != tt.err {
+					t.Errorf("got error %v, want %s", got.err, tt.err)
+				}
+				return
+			}
+			if got.err != nil || got.choice != tt.want.choice || got.value != tt.want.value || got.yes != tt.want.yes {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReviewNoAccountCreation(t *testing.T) {
+	r := newReview(10, nil)
+	r.send(rowMsg(line{row: 3, description: "TESCO", amount: money.MustParse("3.00", "")}))
+	q := question{kind: askAccount, title: "row 3", reply: make(chan answer, 1)}
+	r.send(q)
+	if view := r.m.View(); strings.Contains(view, "ctrl+n") {
+		t.Errorf("view offers to create an account:\n%s", view)
+	}
+	for _, k := range "Tesco\x0e\x1b" {
+		r.send(keyPress(k))
+	}
+	var u *unresolvedError
+	if got := <-q.reply; !errors.As(got.err, &u) || got.value != "" {
+		t.Errorf("got %+v, want no account given", got)
+	}
+}
+
+func TestReviewNoOptions(t *testing.T) {
+	r := newReview(10, nil)
+	r.send(rowMsg(line{row: 3, description: "TESCO", amount: money.MustParse("3.00", "")}))
+	q := question{kind: askPick, title: "row 3", reply: make(chan answer, 1)}
+	r.send(q)
+	if view := r.m.View(); !strings.Contains(view, "No transaction found") {
+		t.Errorf("view does not say no transaction was found:\n%s", view)
+	}
+	// there is nothing to accept or edit, only a new transaction to create
+	for _, k := range "jaec" {
+		r.send(keyPress(k))
+	}
+	if got := <-q.reply; got.err != nil || got.choice != (choice{index: -1}) {
+		t.Errorf("got %+v, want to create a transaction", got)
+	}
+}
+
+func TestReviewDuplicates(t *testing.T) {
+	group := []candidate{
+		{groupID: 1, Transaction: Transaction{Description: "TESCO", Amount: money.MustParse("3.00", "")}},
+		{groupID: 2, Transaction: Transaction{Description: "TESCO STORES", Amount: money.MustParse("3.00", "")}},
+	}
+	for _, tt := range []struct {
+		keys   string
+		action string
+		keep   int
+		err    string
+	}{
+		{"m", "merge", 1, ""},
+		{"kd", "delete", 0, ""},
+		{"jt", "tag", 1, ""},
+		{"s", "", 0, "skipped"},
+	} {
+		r := newReview(3, nil)
+		r.m.noun, r.m.outcomes = "group", []string{"merged", "skipped"}
+		q := question{kind: askDuplicates, title: "Duplicates of #2", options: group, cursor: 1, reply: make(chan answer, 1)}
+		r.send(q)
+		if view := r.m.View(); !strings.Contains(view, "group 0 of 3  merged 0 · skipped 0") || !strings.Contains(view, "#2") {
+			t.Errorf("view does not show the group:\n%s", view)
+		}
+		for _, k := range tt.keys {
+			r.send(keyPress(k))
+		}
+		got := <-q.reply
+		var u *unresolvedError
+		if tt.err != "" {
+			if !errors.As(got.err, &u) || u.reason != tt.err {
+				t.Errorf("%s: got error %v, want %s", tt.keys, got.err, tt.err)
+			}
+			continue
+		}
+		if got.err != nil || got.value != tt.action || got.index != tt.keep {
+			t.Errorf("%s: got %+v, want %s keeping %d", tt.keys, got, tt.action, tt.keep)
+		}
+	}
+}
+
+func TestReviewTally(t *testing.T) {
+	r := newReview(4, nil)
+	for row, outcome := range []string{outcomeMatched, outcomeCreated, outcomeMatched, outcomeSkipped} {
+		r.finish(row+1, outcome)
+	}
+	if got, want := r.m.header(), "100%  row 4 of 4  matched 2 · created 1 · skipped 1"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
+
+func keyPress(r rune) tea.KeyPressMsg {
+	switch r {
+	case '\r':
+		return tea.KeyPressMsg{Code: tea.KeyEnter}
+	case '\x1b':
+		return tea.KeyPressMsg{Code: tea.KeyEscape}
+	case '\x7f':
+		return tea.KeyPressMsg{Code: tea.KeyBackspace}
+	case '↓':
+		return tea.KeyPressMsg{Code: tea.KeyDown}
+	case '\x0e':
+		return tea.KeyPressMsg{Code: 'n', Mod: tea.ModCtrl}
+	}
+	return tea.KeyPressMsg{Code: r, Text: string(r)}
+}
@@ -0,0 +1,71 @@
+package firefly
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"go.grg.app/gdpr/internal/money"
+)
+
+// score rates how well c matches li from 0 to 1. It weighs how close the
+// date and amount, or foreign amount, are, relative to the window and
+// tolerance searched, how similar the descriptions are and whether the
+// opposing account is the one rule maps li to.
+func score(li line, c candidate, window time.Duration, tolerance money.Money, rule MappingRule) float64 {
+	day := 24 * time.Hour
+	d := min(c.Date.Sub(li.date).Abs(), c.Date.Sub(li.paymentDate).Abs())
+	date := max(0, 1-float64(d)/float64(window+day))
+
+	delta := math.Abs(math.Abs(c.Amount.Float64()) - li.amount.Float64())
+	if !li.foreign.IsZero() && strings.EqualFold(c.ForeignCurrencyCode, li.foreign.Currency) {
+		delta = min(delta, math.Abs(math.Abs(c.ForeignAmount.Float64())-li.foreign.Float64()))
+	}
+	// amounts a unit of the currency apart still score
+	unit := math.Pow10(-money.Decimals(li.amount.Currency))
+	amount := max(0, 1-delta/(tolerance.Float64()+unit))
+
+	account := 0.5
+	if rule.AccountID != 0 {
+		opposing := c.SourceID
+		if li.payment {
+			opposing = c.DestinationID
+		}
+		account = 0
+		if int(opposing) == rule.AccountID {
+			account = 1
+		}
+	}
+
+	return 0.3*date + 0.3*amount + 0.3*similarity(c.Description, li.description) + 0.1*account
+}
+
+// similarity returns the Sørensen–Dice coefficient of the letter and digit
+// bigrams of a and b, ignoring case.
+func similarity(a, b string) float64 {
+	x, y := bigrams(a), bigrams(b)
+	if len(x)+len(y) == 0 {
+		return 0
+	}
+	var shared int
+	for g, n := range x {
+		shared += min(n, y[g])
+	}
+	var total int
+	for _, n := range x {
+		total += n
+	}
+	for _, n := range y {
+		total += n
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+func bigrams(s string) map[string]int {
+	r := []rune(strings.ToLower(strings.Map(alnum, s)))
+	m := make(map[string]int, len(r))
+	for i := 1; i < len(r); i++ {
+		m[string(r[i-1:i+1])]++
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package firefly
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.grg.app/gdpr/internal/csvhead"
+)
+
+// statement reads the Account,Date,Description,Payments,Receipts,Running
+// CSV written by barclays and reexport.
+type statement struct {
+	*csv.Reader
+	m Match
+	// account and running are the indexes of their columns, or -1.
+	account, running int
+	accountID        int
+	cache            *accounts
+	byName           map[string]int
+}
+
+// readStatement reads the header of file. The account of every row is
+// accountID if set, or else given by the Account column.
+func readStatement(ctx context.Context, a API, file []byte, accountID int) (*statement, error) {
+	s := &statement{
+		Reader:    csv.NewReader(bytes.NewReader(file)),
+		accountID: accountID,
+		cache:     &accounts{ctx: ctx, a: a},
+		byName:    make(map[string]int),
+	}
+	s.ReuseRecord = true
+	var err error
+	if s.m.profile, err = LoadProfile("barclays"); err != nil {
+		return nil, err
+	}
+	header, err := s.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if err := s.m.layout(header); err != nil {
+		return nil, err
+	}
+	s.account = csvhead.New(header).Index("Account")
+	s.running = csvhead.New(header).Index("Running")
+	if s.account < 0 && accountID == 0 {
+		return nil, errors.New("--account-id must be given without an Account column")
+	}
+	return s, nil
+}
+
+// parse reads the row numbered row.
+func (s *statement) parse(row int, record []string) (line, error) {
+	return s.m.parse(row, record)
+}
+
+// accountOf returns the asset account of record.
+func (s *statement) accountOf(record []string) (int, error) {
+	if s.accountID != 0 {
+		return s.accountID, nil
+	}
+	name := strings.TrimSpace(record[s.account])
+	if id, ok := s.byName[name]; ok {
+		return id, nil
+	}
+	list, err := s.cache.load()
+	if err != nil {
+		return 0, err
+	}
+	id, err := assetAccount(list, name)
+	if err != nil {
+		return 0, err
+	}
+	s.byName[name] = id
+	return id, nil
+}
+
+// assetAccount returns the ID of the asset account named by the Account
+// column, which is either its ID or, as written by barclays, its sort code
+// and account number.
+func assetAccount(list []Object[Account], name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	fields := strings.Fields(name)
+	for _, o := range list {
+		number := o.Attributes.AccountNumber
+		if o.Attributes.Type == "asset" && number != "" && (number == name || len(fields) > 0 && number == fields[len(fields)-1]) {
+			return int(o.ID), nil
+		}
+	}
+	return 0, fmt.Errorf("no asset account has account number %q", name)
+}
@@ -0,0 +1,340 @@
+package firefly
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// reversal is a request that reverses a change made during a session, or
+// else a row of the progress journal at Journal to attempt again.
+type reversal struct {
+	Method  string         `json:"method,omitzero"`
+	Path    string         `json:"path,omitzero"`
+	Body    jsontext.Value `json:"body,omitzero"`
+	Journal string         `json:"journal,omitzero"`
+	Row     int            `json:"row,omitzero"`
+}
+
+// UndoLog is an [http.RoundTripper] that passes requests to Transport and,
+// for each change Firefly accepts, records the request that would reverse
+// it to a session file in Dir: updates are reversed by restoring the
+// previous values of the fields they set, creations by deleting what was
+// created and deletions by creating it again. Rows a match session records
+// in its progress journal are recorded too, so they are attempted again
+// once the session is undone.
+//
+// Previous values are read with Fetch, or Transport if it is nil, so a
+// transport that records requests can be bypassed. They are read once for
+// each change, however often it is retried.
+type UndoLog struct {
+	Dir       string
+	Transport http.RoundTripper
+	Fetch     http.RoundTripper
+
+	mu      sync.Mutex
+	session string
+	f       *os.File
+	// before holds the previous values of resources by path until the
+	// change to them succeeds.
+	before map[string]jsontext.Value
+}
+
+// Session returns the name of the session, or "" if no changes were made.
+func (u *UndoLog) Session() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.session
+}
+
+// Close closes the session file.
+func (u *UndoLog) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.f == nil {
+		return nil
+	}
+	return u.f.Close()
+}
+
+func (u *UndoLog) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := u.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	path, ok := apiPath(req)
+	if !ok || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.RoundTrip(req)
+	}
+
+	var rev *reversal
+	switch req.Method {
+	case http.MethodPut:
+		body, err := readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		before, err := u.fetch(req, path)
+		if err != nil {
+			return nil, err
+		}
+		restore, err := restoreBody(body, before)
+		if err != nil {
+			return nil, err
+		}
+		rev = &reversal{Method: http.MethodPut, Path: path, Body: restore}
+	case http.MethodDelete:
+		before, err := u.fetch(req, path)
+		if err != nil {
+			return nil, err
+		}
+		rev = &reversal{Method: http.MethodPost, Path: parent(path), Body: before}
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil || res.StatusCode >= 300 {
+		return res, err
+	}
+	if req.Method == http.MethodPost {
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(b))
+		var created struct {
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(b, &created); err == nil && created.Data.ID != "" {
+			rev = &reversal{Method: http.MethodDelete, Path: path + "/" + created.Data.ID}
+		}
+	}
+	u.mu.Lock()
+	delete(u.before, path)
+	u.mu.Unlock()
+	if rev != nil {
+		if err := u.record(*rev); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (u *UndoLog) record(r reversal) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.f == nil {
+		if err := os.MkdirAll(u.Dir, 0o700); err != nil {
+			return err
+		}
+		// each session has a file of its own, even if another starts in
+		// the same instant
+		for u.f == nil {
+			u.session = time.Now().Format("20060102-150405.000000000")
+			f, err := os.OpenFile(filepath.Join(u.Dir, u.session+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			u.f = f
+		}
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = u.f.Write(append(b, '\n'))
+	return err
+}
+
+// apiPath returns the path of req relative to the API root, such as
+// "transactions/10".
+func apiPath(req *http.Request) (string, bool) {
+	_, path, ok := strings.Cut(req.URL.Path, "/api/v1/")
+	return path, ok
+}
+
+func parent(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// fetch returns the attributes of the resource at path that req is about
+// to change, as they were before the first attempt at the change.
+func (u *UndoLog) fetch(req *http.Request, path string) (jsontext.Value, error) {
+	u.mu.Lock()
+	before, ok := u.before[path]
+	u.mu.Unlock()
+	if ok {
+		return before, nil
+	}
+	t := cmp.Or(u.Fetch, u.Transport, http.DefaultTransport)
+	before, err := fetch(t, req)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.before == nil {
+		u.before = make(map[string]jsontext.Value)
+	}
+	u.before[path] = before
+	return before, nil
+}
+
+// fetch returns the attributes of the resource req is about to change.
+func fetch(t http.RoundTripper, req *http.Request) (jsontext.Value, error) {
+	get, err := http.NewRequestWithContext(req.Context(), http.MethodGet, req.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	get.Header = req.Header.Clone()
+	res, err := t.RoundTrip(get)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, newAPIError(res)
+	}
+	var resp struct {
+		Data struct {
+			Attributes jsontext.Value `json:"attributes"`
+		} `json:"data"`
+	}
+	err = json.UnmarshalRead(res.Body, &resp)
+	return resp.Data.Attributes, err
+}
+
+// restoreBody returns an update setting each field set by the update body
+// back to its value in before, the attributes of the resource beforehand.
+// Splits of a transaction are paired by journal ID, or else by position.
+func restoreBody(body, before jsontext.Value) (jsontext.Value, error) {
+	var update, prev map[string]any
+	if err := json.Unmarshal(body, &update); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(before, &prev); err != nil {
+		return nil, err
+	}
+	restore := make(map[string]any, len(update))
+	for k := range update {
+		restore[k] = prev[k]
+	}
+	splits, _ := update["transactions"].([]any)
+	prevSplits, _ := prev["transactions"].([]any)
+	if splits != nil {
+		restored := make([]any, len(splits))
+		for i, split := range splits {
+			s, _ := split.(map[string]any)
+			j := slices.IndexFunc(prevSplits, func(prev any) bool {
+				p, _ := prev.(map[string]any)
+				return s["transaction_journal_id"] != nil && p["transaction_journal_id"] == s["transaction_journal_id"]
+			})
+			if j < 0 && i < len(prevSplits) {
+				j = i
+			}
+			var p map[string]any
+			if j >= 0 {
+				p, _ = prevSplits[j].(map[string]any)
+			}
+			r := make(map[string]any, len(s))
+			for k := range s {
+				r[k] = p[k]
+			}
+			if id, ok := p["transaction_journal_id"]; ok {
+				r["transaction_journal_id"] = id
+			}
+			restored[i] = r
+		}
+		restore["transactions"] = restored
+	}
+	return json.Marshal(restore)
+}
+
+// Undo reverses the changes made during a session, as recorded by
+// [UndoLog], in the reverse order they were made.
+type Undo struct {
+	Session string `arg:"" help:"Session to undo, as logged when it made changes"`
+	// Dir is the directory of undo logs, set from the global --undo-dir.
+	Dir string `kong:"-"`
+}
+
+func (u Undo) Run(ctx context.Context, a API) error {
+	path := filepath.Join(u.Dir, u.Session+".jsonl")
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	var revs []reversal
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var r reversal
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			f.Close()
+			return fmt.Errorf("undo log %s: %w", path, err)
+		}
+		revs = append(revs, r)
+	}
+	f.Close()
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	var (
+		errs     error
+		journals = make(map[string][]int)
+	)
+	for _, r := range slices.Backward(revs) {
+		if r.Journal != "" {
+			journals[r.Journal] = append(journals[r.Journal], r.Row)
+			continue
+		}
+		slog.Info("undoing", slog.String("method", r.Method), slog.String("path", r.Path))
+		var body io.Reader
+		if len(r.Body) > 0 {
+			body = bytes.NewReader(r.Body)
+		}
+		var out any
+		err := Do(ctx, a, r.Method, r.Path, nil, &out, body)
+		var apiErr *APIError
+		if r.Method == http.MethodDelete && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			slog.Warn("already deleted", slog.String("path", r.Path))
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to undo", slog.String("method", r.Method), slog.String("path", r.Path), slog.String("err", err.Error()))
+			errs = errors.Join(errs, fmt.Errorf("%s %s: %w", r.Method, r.Path, err))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	for path, rows := range journals {
+		if err := undoJournal(path, rows); err != nil {
+			return err
+		}
+	}
+	return os.Rename(path, path+".undone")
+}
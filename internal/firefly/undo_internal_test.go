@@ -0,0 +1,61 @@
+package firefly
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// transportFunc is an [http.RoundTripper] calling itself.
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestUndoLogRetry(t *testing.T) {
+	tag, puts := "old", 0
+	a := testAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			// the first attempt is applied but fails anyway
+			tag, puts = "new", puts+1
+			if puts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintf(w, `{"data": {"id": "1", "attributes": {"tag": %q}}}`, tag)
+	})
+	var fetches int
+	u := &UndoLog{
+		Dir: t.TempDir(),
+		Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodGet {
+				t.Error("previous values read through Transport")
+			}
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+		Fetch: transportFunc(func(req *http.Request) (*http.Response, error) {
+			fetches++
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}
+	a.Transport = u
+	var out any
+	if err := Do(t.Context(), a, http.MethodPut, "tags/1", nil, &out, strings.NewReader(`{"tag": "new"}`)); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+
+	if puts != 2 || fetches != 1 {
+		t.Errorf("got %d attempts reading previous values %d times, want 2 reading once", puts, fetches)
+	}
+	b, err := os.ReadFile(filepath.Join(u.Dir, u.Session()+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"method":"PUT","path":"tags/1","body":{"tag":"old"}}`; strings.TrimSpace(string(b)) != want {
+		t.Errorf("got undo log %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,115 @@
+package firefly_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.grg.app/gdpr/internal/firefly"
+	"go.grg.app/gdpr/internal/firefly/fireflytest"
+	"go.grg.app/gdpr/internal/money"
+)
+
+func TestUndo(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	dinner := seed(s,
+		firefly.Transaction{Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("30.00", ""), Description: "Dinner", Notes: "0.5 1001"},
+		firefly.Transaction{Type: "deposit", Date: time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("15.00", ""), Description: "Repayment", SourceID: 7, DestinationID: 1, ExternalID: "1001"},
+	)[0]
+
+	dir := t.TempDir()
+	a := s.API()
+	u := &firefly.UndoLog{Dir: dir}
+	a.Transport = u
+	m := newMatch(t, "01 Feb 24,RESTAURANT,-30.00\n")
+	if err := m.Run(t.Context(), a); err != nil {
+		t.Fatal(err)
+	}
+	if err := (firefly.Link{Query: "has_any_notes:true"}).Run(t.Context(), a); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+	if len(s.Links()) != 1 {
+		t.Fatalf("got %d links before undo, want 1", len(s.Links()))
+	}
+
+	if err := (firefly.Undo{Session: u.Session(), Dir: dir}).Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if links := s.Links(); len(links) != 0 {
+		t.Errorf("got links %v after undo", links)
+	}
+	g, _ := s.Transaction(dinner)
+	if got := g.Transactions[0]; len(got.Tags) != 0 || !got.PaymentDate.IsZero() || got.Notes != "0.5 1001" {
+		t.Errorf("transaction not restored: %+v", got)
+	}
+}
+
+func TestUndoJournal(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	id := seed(s, firefly.Transaction{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("12.34", ""), Description: "Tesco"})[0]
+	tagged := func() bool {
+		g, _ := s.Transaction(id)
+		return slices.Contains(g.Transactions[0].Tags, "gdpr")
+	}
+
+	dir := t.TempDir()
+	a := s.API()
+	u := &firefly.UndoLog{Dir: dir}
+	a.Transport = u
+	m := newMatch(t, "01 Jan 24,TESCO STORES,-12.34\n")
+	m.NonInteractive = true
+	if err := m.Run(t.Context(), a); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+	if err := (firefly.Undo{Session: u.Session(), Dir: dir}).Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if tagged() {
+		t.Fatal("still tagged after undo")
+	}
+
+	// the undone row is matched again rather than skipped as done
+	if err := m.Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if !tagged() {
+		t.Error("not tagged rerunning after undo")
+	}
+}
+
+func TestUndoSessions(t *testing.T) {
+	s := fireflytest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	var (
+		sessions []string
+		ids      []int
+	)
+	for _, desc := range []string{"First", "Second"} {
+		a := s.API()
+		u := &firefly.UndoLog{Dir: dir}
+		a.Transport = u
+		o, err := a.CreateTransaction(t.Context(), firefly.TransactionGroup{Transactions: []firefly.Transaction{{
+			Type: "withdrawal", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Amount: money.MustParse("1.00", ""),
+			Description: desc, SourceID: 1, DestinationID: 5,
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.Close()
+		sessions, ids = append(sessions, u.Session()), append(ids, int(o.ID))
+	}
+	if sessions[0] == sessions[1] {
+		t.Fatalf("both sessions are %s", sessions[0])
+	}
+	if err := (firefly.Undo{Session: sessions[0], Dir: dir}).Run(t.Context(), s.API()); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Transactions(); !slices.Equal(got, ids[1:]) {
+		t.Errorf("got transactions %v after undoing the first session, want %v", got, ids[1:])
+	}
+}
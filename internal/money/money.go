@@ -0,0 +1,170 @@
+// Package money represents amounts of money exactly, in the minor units of
+// their currency.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// decimals holds the number of decimal places of ISO 4217 currencies that do
+// not have two.
+var decimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Decimals returns the number of decimal places of currency, an ISO 4217
+// code. Unknown currencies, including "", have two.
+func Decimals(currency string) int {
+	if d, ok := decimals[strings.ToUpper(currency)]; ok {
+		return d
+	}
+	return 2
+}
+
+// Money is an amount in the minor units of Currency, such as pence for GBP.
+// It marshals as a decimal string, such as "-12.34".
+type Money struct {
+	Units    int64
+	Currency string
+}
+
+// New returns units minor units of currency.
+func New(units int64, currency string) Money {
+	return Money{Units: units, Currency: currency}
+}
+
+// Parse parses a decimal amount of currency, such as "-1,234.56". Digits
+// beyond the decimal places of currency must be zeros, such as those
+// Firefly pads amounts with; anything else is an error rather than rounded.
+func Parse(s, currency string) (Money, error) {
+	m := Money{Currency: currency}
+	in := s
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return m, fmt.Errorf("invalid amount %q", in)
+	}
+	d := Decimals(currency)
+	if len(frac) > d {
+		if strings.Trim(frac[d:], "0") != "" {
+			return m, fmt.Errorf("invalid amount %q: more than %d decimal places", in, d)
+		}
+		frac = frac[:d]
+	}
+	frac += strings.Repeat("0", d-len(frac))
+	var units int64
+	if digits := strings.TrimLeft(whole+frac, "0"); digits != "" {
+		var err error
+		if units, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			return m, fmt.Errorf("invalid amount %q: %w", in, err)
+		}
+	}
+	if neg {
+		units = -units
+	}
+	m.Units = units
+	return m, nil
+}
+
+// MustParse is like [Parse] but panics if s is invalid.
+func MustParse(s, currency string) Money {
+	m, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m Money) String() string {
+	d := Decimals(m.Currency)
+	units := m.Units
+	var sign string
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	s := strconv.FormatInt(units, 10)
+	if d == 0 {
+		return sign + s
+	}
+	if len(s) <= d {
+		s = strings.Repeat("0", d-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d] + "." + s[len(s)-d:]
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText parses text in the currency m already has.
+func (m *Money) UnmarshalText(text []byte) error {
+	v, err := Parse(string(text), m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// IsZero reports whether m is zero, in any currency.
+func (m Money) IsZero() bool { return m.Units == 0 }
+
+// Sign returns -1, 0 or 1 as m is negative, zero or positive.
+func (m Money) Sign() int {
+	switch {
+	case m.Units < 0:
+		return -1
+	case m.Units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) Neg() Money { return Money{-m.Units, m.Currency} }
+
+func (m Money) Abs() Money {
+	if m.Units < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// ErrCurrency is the panic value of arithmetic on amounts of different
+// currencies.
+var ErrCurrency = errors.New("money: currencies differ")
+
+// Comparable reports whether m and n can be added, subtracted or compared:
+// they are in the same currency, or either has none.
+func (m Money) Comparable(n Money) bool {
+	return m.Currency == "" || n.Currency == "" || strings.EqualFold(m.Currency, n.Currency)
+}
+
+// Add returns m+n. An amount with no currency takes the currency of the
+// other, and Add panics with [ErrCurrency] if the currencies differ; check
+// amounts from different sources with [Money.Comparable] first.
+func (m Money) Add(n Money) Money {
+	if !m.Comparable(n) {
+		panic(ErrCurrency)
+	}
+	if m.Currency == "" {
+		m.Currency = n.Currency
+	}
+	m.Units += n.Units
+	return m
+}
+
+// Sub returns m-n, as [Money.Add].
+func (m Money) Sub(n Money) Money { return m.Add(n.Neg()) }
+
+// Float64 returns m in major units, such as pounds, which may be inexact.
+func (m Money) Float64() float64 {
+	return float64(m.Units) / math.Pow10(Decimals(m.Currency))
+}
@@ -0,0 +1,76 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		in, currency string
+		units        int64
+		out          string
+	}{
+		{"12.34", "GBP", 1234, "12.34"},
+		{"-0.5", "", -50, "-0.50"},
+		{"1,234.5", "EUR", 123450, "1234.50"},
+		{"3.000000000000", "GBP", 300, "3.00"},
+		{"12.340", "GBP", 1234, "12.34"},
+		{"1500", "JPY", 1500, "1500"},
+		{"1500.000000000000", "JPY", 1500, "1500"},
+		{"1.234", "BHD", 1234, "1.234"},
+		{"0.001", "bhd", 1, "0.001"},
+		{".5", "", 50, "0.50"},
+		{"+7", "", 700, "7.00"},
+	} {
+		m, err := Parse(tt.in, tt.currency)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.in, tt.currency, err)
+			continue
+		}
+		if m.Units != tt.units || m.String() != tt.out {
+			t.Errorf("%s %s: got %d units %s, want %d units %s", tt.in, tt.currency, m.Units, m, tt.units, tt.out)
+		}
+	}
+	for _, in := range []string{"", "-", "1.2.3", "£5", "abc", "99999999999999999999", "12.345", "-0.125"} {
+		if _, err := Parse(in, "GBP"); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+	// digits dropped from currencies with fewer decimal places are not rounded
+	for in, currency := range map[string]string{"1500.5": "JPY", "1.2345": "KWD", "0.001": ""} {
+		if _, err := Parse(in, currency); err == nil {
+			t.Errorf("%q %s: expected error", in, currency)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	// summing a penny a million times as float64 drifts; Money does not
+	var sum Money
+	for range 1_000_000 {
+		sum = sum.Add(New(1, "GBP"))
+	}
+	if sum.String() != "10000.00" || sum.Currency != "GBP" {
+		t.Errorf("got %s %s", sum, sum.Currency)
+	}
+	defer func() {
+		if recover() != ErrCurrency {
+			t.Error("expected panic adding different currencies")
+		}
+	}()
+	New(1, "GBP").Add(New(1, "EUR"))
+}
+
+func TestComparable(t *testing.T) {
+	for _, tt := range []struct {
+		m, n Money
+		want bool
+	}{
+		{New(1, "GBP"), New(1, "gbp"), true},
+		{New(1, "GBP"), New(1, ""), true},
+		{New(1, ""), New(1, "EUR"), true},
+		{New(1, "GBP"), New(1, "EUR"), false},
+	} {
+		if got := tt.m.Comparable(tt.n); got != tt.want {
+			t.Errorf("%s %s comparable to %s %s: got %v", tt.m, tt.m.Currency, tt.n, tt.n.Currency, got)
+		}
+	}
+}